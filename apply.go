@@ -0,0 +1,300 @@
+package nfsmanager
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ChangeAction is the kind of change Apply makes to an export.
+type ChangeAction string
+
+const (
+	// ActionExport exports a path to a client it wasn't exported to.
+	ActionExport ChangeAction = "export"
+	// ActionReExport exports a path to a client again with new options.
+	ActionReExport ChangeAction = "re-export"
+	// ActionUnExport removes an export no longer desired.
+	ActionUnExport ChangeAction = "unexport"
+)
+
+// Change describes a single change Apply made to the export table.
+type Change struct {
+	Action ChangeAction `json:"action"`
+	Path   string       `json:"path"`
+	Client Client       `json:"client"`
+	// Options are the desired options. They are nil for ActionUnExport.
+	Options []Option `json:"options,omitempty"`
+	// Previous are the options the path was exported with before. They
+	// are nil for ActionExport.
+	Previous []Option `json:"previous,omitempty"`
+}
+
+func (c Change) String() string {
+	switch c.Action {
+	case ActionUnExport:
+		return fmt.Sprintf("%s %s:%s", c.Action, c.Client, c.Path)
+	default:
+		return fmt.Sprintf("%s %s:%s(%s)", c.Action, c.Client, c.Path, optionsString(c.Options))
+	}
+}
+
+// ChangeReport lists the changes made by Apply, in the order they were
+// made.
+type ChangeReport struct {
+	Changes []Change
+}
+
+// Empty reports whether no changes were made.
+func (r ChangeReport) Empty() bool {
+	return len(r.Changes) == 0
+}
+
+// optionGroups lists options that switch the same setting on or off.
+// The first option of each group is the default exportfs assumes when
+// none of them is given.
+var optionGroups = [][]string{
+	{"ro", "rw"},
+	{"sync", "async"},
+	{"wdelay", "no_wdelay"},
+	{"hide", "nohide"},
+	{"nocrossmnt", "crossmnt"},
+	{"no_subtree_check", "subtree_check"},
+	{"secure", "insecure"},
+	{"secure_locks", "insecure_locks"},
+	{"root_squash", "no_root_squash"},
+	{"no_all_squash", "all_squash"},
+	{"rdirplus", "nordirplus"},
+	{"no_pnfs", "pnfs"},
+	{"acl", "no_acl"},
+}
+
+// optionValueDefaults are the values exportfs assumes for options that
+// take a value when they are not given.
+var optionValueDefaults = map[string]string{
+	"anonuid": "anonuid=65534",
+	"anongid": "anongid=65534",
+	"sec":     "sec=sys",
+}
+
+// optionGroup returns the group name belongs to, if any.
+func optionGroup(name string) ([]string, bool) {
+	for _, group := range optionGroups {
+		for _, member := range group {
+			if member == name {
+				return group, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// effectiveOptions returns the settings options result in once the
+// defaults exportfs fills in are taken into account, so that the
+// options we ask for can be compared with those exportfs -v reports.
+//
+// The per-flavor options are tracked for each sec= flavor the way
+// nfs-utils does: a flavor starts with the export's settings at its
+// sec= option, and per-flavor options that follow change both the
+// export's settings and those of the flavors of the last sec= option.
+// Without any sec=, the export has a single sys flavor.
+func effectiveOptions(options []Option) map[string]string {
+	effective := make(map[string]string)
+	for _, group := range optionGroups {
+		effective[group[0]] = group[0]
+	}
+	for name, value := range optionValueDefaults {
+		effective[name] = value
+	}
+
+	var flavors, active []string
+	flavorSettings := make(map[string]map[string]string)
+	for _, opt := range options {
+		opt = opt.canonical()
+		str := opt.string()
+		if str == "" {
+			continue
+		}
+		if opt.optionString == "sec" {
+			active = nonEmpty(opt.extra)
+			for _, flavor := range active {
+				if _, ok := flavorSettings[flavor]; !ok {
+					flavors = append(flavors, flavor)
+				}
+				flavorSettings[flavor] = perFlavorSettings(effective)
+			}
+			continue
+		}
+
+		key := opt.optionString
+		if group, ok := optionGroup(key); ok {
+			key = group[0]
+		}
+		if key == "fsid" && len(opt.extra) > 0 {
+			// exportfs -v reports fsid=root as fsid=0 and drops
+			// leading zeros.
+			str = "fsid=" + normalizeFsID(opt.extra[0])
+		}
+		effective[key] = str
+		if perFlavorOptions[key] {
+			for _, flavor := range active {
+				flavorSettings[flavor][key] = str
+			}
+		}
+	}
+
+	if len(flavors) == 0 {
+		flavors = []string{"sys"}
+		flavorSettings["sys"] = perFlavorSettings(effective)
+	}
+	effective["sec"] = "sec=" + strings.Join(flavors, ":")
+	for _, flavor := range flavors {
+		for key, value := range flavorSettings[flavor] {
+			effective["sec="+flavor+":"+key] = value
+		}
+	}
+	return effective
+}
+
+// perFlavorSettings returns the per-flavor settings in effective.
+func perFlavorSettings(effective map[string]string) map[string]string {
+	settings := make(map[string]string)
+	for key, value := range effective {
+		if perFlavorOptions[key] {
+			settings[key] = value
+		}
+	}
+	return settings
+}
+
+func equivalentOptions(a, b []Option) bool {
+	ea, eb := effectiveOptions(a), effectiveOptions(b)
+	if len(ea) != len(eb) {
+		return false
+	}
+	for k, v := range ea {
+		if eb[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+type exportKey struct {
+	path   string
+	client Client
+}
+
+// flattenExports returns the client exports keyed by path and client,
+// along with the keys in the order they appear.
+func flattenExports(exports []Export) (map[exportKey]ClientExport, []exportKey, error) {
+	flat := make(map[exportKey]ClientExport)
+	var order []exportKey
+	for _, export := range exports {
+		for _, client := range export.Clients {
+			key := exportKey{export.Path, client.Client}
+			if _, ok := flat[key]; ok {
+				return nil, nil, fmt.Errorf("%s:%s listed more than once", client.Client, export.Path)
+			}
+			flat[key] = client
+			order = append(order, key)
+		}
+	}
+	return flat, order, nil
+}
+
+// currentExports returns the exports Apply converges: the live export
+// table, or the managed file of a persistent manager.
+func (n *Manager) currentExports(ctx context.Context) ([]Export, error) {
+	if n.Owner != "" {
+		return n.PersistedExports()
+	}
+	return n.ListExportsContext(ctx)
+}
+
+// planChanges returns the changes needed to turn the current export
+// table into desired without making them.
+func (n *Manager) planChanges(ctx context.Context, desired []Export) ([]Change, error) {
+	want, wantOrder, err := flattenExports(desired)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range wantOrder {
+		if err := ValidateOptions(want[key].Options...); err != nil {
+			return nil, fmt.Errorf("%s:%s: %w", key.client, key.path, err)
+		}
+	}
+
+	current, err := n.currentExports(ctx)
+	if err != nil {
+		return nil, err
+	}
+	have, haveOrder, err := flattenExports(current)
+	if err != nil {
+		return nil, err
+	}
+
+	var removals, updates, additions []Change
+	for _, key := range haveOrder {
+		if _, ok := want[key]; !ok {
+			removals = append(removals, Change{Action: ActionUnExport, Path: key.path, Client: key.client, Previous: have[key].Options})
+		}
+	}
+	for _, key := range wantOrder {
+		existing, ok := have[key]
+		switch {
+		case !ok:
+			additions = append(additions, Change{Action: ActionExport, Path: key.path, Client: key.client, Options: want[key].Options})
+		case !equivalentOptions(existing.Options, want[key].Options):
+			updates = append(updates, Change{Action: ActionReExport, Path: key.path, Client: key.client, Options: want[key].Options, Previous: existing.Options})
+		}
+	}
+
+	return append(append(removals, updates...), additions...), nil
+}
+
+// Apply converges the export table on desired. Exports that are not
+// desired are removed, exports whose options differ are exported again
+// with the desired options and missing exports are added. Exports that
+// already match are left alone, so applying the same exports twice
+// makes no changes the second time.
+//
+// Options are compared after filling in the defaults exportfs assumes,
+// so desiring RW matches a live export reported as "rw,sync,wdelay,...".
+//
+// A persistent manager converges its managed file rather than the live
+// export table. On failure the report lists the changes made before the
+// failing one.
+func (n *Manager) Apply(desired []Export) (ChangeReport, error) {
+	return n.ApplyContext(context.Background(), desired)
+}
+
+// ApplyContext is like Apply, but stops making changes once ctx is
+// done, killing any exportfs that is running at the time.
+func (n *Manager) ApplyContext(ctx context.Context, desired []Export) (ChangeReport, error) {
+	var report ChangeReport
+
+	changes, err := n.planChanges(ctx, desired)
+	if err != nil {
+		return report, err
+	}
+
+	for _, change := range changes {
+		if err := n.applyChange(ctx, change); err != nil {
+			return report, fmt.Errorf("%s: %w", change, err)
+		}
+		report.Changes = append(report.Changes, change)
+	}
+	return report, nil
+}
+
+func (n *Manager) applyChange(ctx context.Context, change Change) error {
+	switch change.Action {
+	case ActionUnExport:
+		return n.UnExportFsContext(ctx, change.Path, change.Client)
+	case ActionExport, ActionReExport:
+		return n.ExportFsContext(ctx, change.Path, change.Client, change.Options...)
+	default:
+		return fmt.Errorf("unknown action %q", change.Action)
+	}
+}
@@ -0,0 +1,201 @@
+package nfsmanager
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeExportfs mimics exportfs on an in-memory export table. Options are
+// reported back the way exportfs -v does, with defaults filled in.
+type fakeExportfs struct {
+	order   []exportKey
+	table   map[exportKey]string
+	calls   [][]string
+	failOn  string
+	listErr error
+}
+
+func newFakeExportfs(exports ...Export) *fakeExportfs {
+	f := &fakeExportfs{table: make(map[exportKey]string)}
+	for _, export := range exports {
+		for _, client := range export.Clients {
+			f.set(exportKey{export.Path, client.Client}, optionsString(client.Options))
+		}
+	}
+	return f
+}
+
+func (f *fakeExportfs) set(key exportKey, options string) {
+	if _, ok := f.table[key]; !ok {
+		f.order = append(f.order, key)
+	}
+	f.table[key] = "sync,wdelay,hide,no_subtree_check,sec=sys,secure,root_squash,no_all_squash," + options
+}
+
+func (f *fakeExportfs) run(ctx context.Context, cmdLine []string, command execCommander, privilege Privilege, logger Logger) ([]byte, error) {
+	if reflect.DeepEqual(cmdLine, listExportsCommandLine()) {
+		if f.listErr != nil {
+			return nil, f.listErr
+		}
+		var out strings.Builder
+		for _, key := range f.order {
+			fmt.Fprintf(&out, "%s\t%s(%s)\n", key.path, key.client, f.table[key])
+		}
+		return []byte(out.String()), nil
+	}
+
+	f.calls = append(f.calls, cmdLine)
+	if f.failOn != "" && strings.Contains(strings.Join(cmdLine, " "), f.failOn) {
+		return nil, fmt.Errorf("Mock failure")
+	}
+
+	unexport := cmdLine[1] == "-u"
+	spec := cmdLine[1]
+	if unexport {
+		spec = cmdLine[2]
+	}
+	i := strings.Index(spec, ":/")
+	client, err := ParseClient(spec[:i])
+	if err != nil {
+		return nil, err
+	}
+	key := exportKey{spec[i+1:], client}
+
+	if unexport {
+		if _, ok := f.table[key]; !ok {
+			return nil, fmt.Errorf("Could not find '%s' to unexport.", spec)
+		}
+		delete(f.table, key)
+		order := f.order[:0]
+		for _, k := range f.order {
+			if k != key {
+				order = append(order, k)
+			}
+		}
+		f.order = order
+		return nil, nil
+	}
+
+	options := ""
+	if len(cmdLine) == 4 {
+		options = cmdLine[3]
+	}
+	f.set(key, options)
+	return nil, nil
+}
+
+func Test_effectiveOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		a    []Option
+		b    []Option
+		want bool
+	}{
+		{"Both empty", nil, nil, true},
+		{"Explicit default", []Option{Sync, Secure}, nil, true},
+		{"Reported defaults", []Option{RW}, parseRawOptions("rw,sync,wdelay,hide,no_subtree_check,sec=sys,secure,root_squash,no_all_squash"), true},
+		{"Synonym", []Option{NoAuthNLM}, []Option{InsecureLocks}, true},
+		{"Last option wins", []Option{ASync, Sync}, nil, true},
+		{"Default anonuid", []Option{AnonUID(65534)}, nil, true},
+		{"Different flag", []Option{RW}, nil, false},
+		{"Different value", []Option{FsID("1")}, []Option{FsID("2")}, false},
+		{"Extra value", []Option{FsID("1")}, nil, false},
+		{"Root fsid", []Option{FsIDRoot, RW}, parseRawOptions("fsid=0,rw"), true},
+		{"Leading zeros in fsid", []Option{FsID("007")}, []Option{FsIDNumber(7)}, true},
+		{"Same per-flavor options", []Option{Sec("krb5p"), RW, Sec("sys"), RO}, parseRawOptions("ro,sec=krb5p,rw,secure,root_squash,no_all_squash,sec=sys,ro,secure,root_squash,no_all_squash"), true},
+		{"Different per-flavor option", []Option{Sec("krb5p"), RW, Sec("sys"), RO}, parseRawOptions("ro,sec=krb5p,ro,sec=sys,ro"), false},
+		{"Default sys flavor", []Option{RW}, []Option{Sec("sys"), RW}, true},
+		{"Different flavors", []Option{Sec("krb5")}, []Option{Sec("krb5p")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := equivalentOptions(tt.a, tt.b); got != tt.want {
+				t.Errorf("equivalentOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_nfsManager_Apply(t *testing.T) {
+	live := []Export{
+		{"/srv/keep", []ClientExport{{Host("10.0.0.1"), []Option{RW}}}},
+		{"/srv/change", []ClientExport{{Host("10.0.0.1"), []Option{RW}}}},
+		{"/srv/remove", []ClientExport{{Host("10.0.0.1"), nil}}},
+	}
+	desired := []Export{
+		{"/srv/keep", []ClientExport{{Host("10.0.0.1"), []Option{RW}}}},
+		{"/srv/change", []ClientExport{{Host("10.0.0.1"), []Option{RW, NoRootSquash}}}},
+		{"/srv/add", []ClientExport{{Anonymous, []Option{AllSquash}}}},
+	}
+
+	fake := newFakeExportfs(live...)
+	n := NFSManager()
+	n.commandRetrier = fake.run
+
+	report, err := n.Apply(desired)
+	if err != nil {
+		t.Fatalf("Manager.Apply() error = %v", err)
+	}
+	want := []Change{
+		{ActionUnExport, "/srv/remove", Host("10.0.0.1"), nil, parseRawOptions("sync,wdelay,hide,no_subtree_check,sec=sys,secure,root_squash,no_all_squash")},
+		{ActionReExport, "/srv/change", Host("10.0.0.1"), []Option{RW, NoRootSquash}, parseRawOptions("sync,wdelay,hide,no_subtree_check,sec=sys,secure,root_squash,no_all_squash,rw")},
+		{ActionExport, "/srv/add", Anonymous, []Option{AllSquash}, nil},
+	}
+	if !reflect.DeepEqual(report.Changes, want) {
+		t.Errorf("Manager.Apply() = %v, want %v", report.Changes, want)
+	}
+	wantCalls := [][]string{
+		unExportFSCommandLine("/srv/remove", Host("10.0.0.1")),
+		exportFSCommandLine("/srv/change", Host("10.0.0.1"), []Option{RW, NoRootSquash}),
+		exportFSCommandLine("/srv/add", Anonymous, []Option{AllSquash}),
+	}
+	if !reflect.DeepEqual(fake.calls, wantCalls) {
+		t.Errorf("exportfs calls = %v, want %v", fake.calls, wantCalls)
+	}
+
+	fake.calls = nil
+	report, err = n.Apply(desired)
+	if err != nil {
+		t.Fatalf("second Manager.Apply() error = %v", err)
+	}
+	if !report.Empty() || len(fake.calls) != 0 {
+		t.Errorf("second Manager.Apply() made changes %v with calls %v, want none", report.Changes, fake.calls)
+	}
+}
+
+func Test_nfsManager_Apply_errors(t *testing.T) {
+	tests := []struct {
+		name        string
+		desired     []Export
+		failOn      string
+		listErr     error
+		wantChanges int
+	}{
+		{"Listing fails", []Export{{"/srv/a", []ClientExport{{Host("10.0.0.1"), nil}}}}, "", fmt.Errorf("Mock failure"), 0},
+		{"Duplicate client", []Export{{"/srv/a", []ClientExport{{Host("10.0.0.1"), nil}, {Host("10.0.0.1"), []Option{RW}}}}}, "", nil, 0},
+		{"Export fails part way", []Export{
+			{"/srv/a", []ClientExport{{Host("10.0.0.1"), nil}}},
+			{"/srv/b", []ClientExport{{Host("10.0.0.1"), nil}}},
+		}, "/srv/b", nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeExportfs()
+			fake.failOn = tt.failOn
+			fake.listErr = tt.listErr
+			n := NFSManager()
+			n.commandRetrier = fake.run
+
+			report, err := n.Apply(tt.desired)
+			if err == nil {
+				t.Fatalf("Manager.Apply() error = nil, want error")
+			}
+			if len(report.Changes) != tt.wantChanges {
+				t.Errorf("Manager.Apply() made %d changes, want %d", len(report.Changes), tt.wantChanges)
+			}
+		})
+	}
+}
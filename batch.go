@@ -0,0 +1,151 @@
+package nfsmanager
+
+import (
+	"context"
+	"fmt"
+)
+
+// Batch is a list of changes to the export table that are made together
+// by Commit. If one of them fails, the export table is restored to how
+// it was before the batch started.
+type Batch struct {
+	n   *Manager
+	ops []batchOp
+}
+
+type batchOp struct {
+	unexport bool
+	path     string
+	client   ClientExport
+}
+
+// Batch returns an empty batch of changes to make with n.
+func (n *Manager) Batch() *Batch {
+	return &Batch{n: n}
+}
+
+// ExportFs adds exporting path to client with options to the batch.
+func (b *Batch) ExportFs(path string, client Client, options ...Option) *Batch {
+	b.ops = append(b.ops, batchOp{path: path, client: ClientExport{Client: client, Options: options}})
+	return b
+}
+
+// UnExportFs adds unexporting path from client to the batch.
+func (b *Batch) UnExportFs(path string, client Client) *Batch {
+	b.ops = append(b.ops, batchOp{unexport: true, path: path, client: ClientExport{Client: client}})
+	return b
+}
+
+// Commit makes the changes in the batch, in order.
+//
+// The export table is snapshot with exportfs -v first. If a change
+// fails, the exports touched by the changes made so far are restored to
+// the snapshot: exports that were added are unexported and the others
+// are exported again with their snapshot options. The returned error is
+// then a *RollbackError holding the failure and any errors that occurred
+// while restoring.
+//
+// A persistent manager makes all changes to its managed file in a single
+// update. If reloading the export table with it fails, the file's
+// previous content is restored and reloaded, and the error is again a
+// *RollbackError.
+func (b *Batch) Commit() error {
+	return b.CommitContext(context.Background())
+}
+
+// CommitContext is like Commit, but stops making changes once ctx is
+// done. The rollback that follows is not bound by ctx.
+func (b *Batch) CommitContext(ctx context.Context) error {
+	n := b.n
+	for _, op := range b.ops {
+		if err := op.client.Client.Validate(); err != nil {
+			return err
+		}
+		if err := ValidateOptions(op.client.Options...); err != nil {
+			return fmt.Errorf("%s:%s: %w", op.client.Client, op.path, err)
+		}
+		if !op.unexport {
+			if err := n.checkMount(op.path, op.client.Options); err != nil {
+				return err
+			}
+		}
+	}
+
+	if n.Owner != "" {
+		return n.updateManagedFile(ctx, func(f *ExportsFile) error {
+			for _, op := range b.ops {
+				if !op.unexport {
+					setClients(f, op.path, op.client)
+				} else if !removeClient(f, op.path, op.client.Client) {
+					return fmt.Errorf("%s:%s is not exported by %s", op.client.Client, op.path, n.managedFilePath())
+				}
+			}
+			return nil
+		})
+	}
+
+	current, err := n.ListExportsContext(ctx)
+	if err != nil {
+		return err
+	}
+	snapshot := make(map[exportKey]ClientExport)
+	for _, export := range current {
+		for _, client := range export.Clients {
+			snapshot[exportKey{export.Path, client.Client}] = client
+		}
+	}
+
+	exported := make(map[exportKey]bool)
+	for key := range snapshot {
+		exported[key] = true
+	}
+	var touched []exportKey
+	for _, op := range b.ops {
+		key := exportKey{op.path, op.client.Client}
+		var err error
+		if op.unexport {
+			err = n.unExportFs(ctx, op.path, op.client.Client)
+		} else {
+			err = n.exportFs(ctx, op.path, op.client.Client, op.client.Options)
+		}
+		if err != nil {
+			return &RollbackError{
+				Err:            fmt.Errorf("%s:%s: %w", op.client.Client, op.path, err),
+				RollbackErrors: n.restore(touched, snapshot, exported),
+			}
+		}
+		touched = append(touched, key)
+		exported[key] = !op.unexport
+	}
+	return nil
+}
+
+// restore returns the exports in keys to their state in snapshot,
+// undoing the most recently touched first. exported tells which of them
+// are currently exported.
+func (n *Manager) restore(keys []exportKey, snapshot map[exportKey]ClientExport, exported map[exportKey]bool) []error {
+	ctx := context.Background()
+
+	var errs []error
+	restored := make(map[exportKey]bool)
+	for i := len(keys) - 1; i >= 0; i-- {
+		key := keys[i]
+		if restored[key] {
+			continue
+		}
+		restored[key] = true
+
+		var err error
+		if client, ok := snapshot[key]; ok {
+			err = n.exportFs(ctx, key.path, key.client, client.Options)
+		} else if exported[key] {
+			err = n.unExportFs(ctx, key.path, key.client)
+		} else {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s:%s: %w", key.client, key.path, err))
+		}
+	}
+	return errs
+}
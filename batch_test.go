@@ -0,0 +1,163 @@
+package nfsmanager
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_Batch_Commit(t *testing.T) {
+	fake := newFakeExportfs(
+		Export{"/srv/a", []ClientExport{{Host("10.0.0.1"), []Option{RW}}}},
+		Export{"/srv/b", []ClientExport{{Host("10.0.0.1"), nil}}},
+	)
+	n := NFSManager()
+	n.commandRetrier = fake.run
+
+	err := n.Batch().
+		ExportFs("/srv/c", Host("10.0.0.1")).
+		UnExportFs("/srv/b", Host("10.0.0.1")).
+		ExportFs("/srv/a", Host("10.0.0.1"), RO).
+		Commit()
+	if err != nil {
+		t.Fatalf("Batch.Commit() error = %v", err)
+	}
+	wantCalls := [][]string{
+		exportFSCommandLine("/srv/c", Host("10.0.0.1"), nil),
+		unExportFSCommandLine("/srv/b", Host("10.0.0.1")),
+		exportFSCommandLine("/srv/a", Host("10.0.0.1"), []Option{RO}),
+	}
+	if !reflect.DeepEqual(fake.calls, wantCalls) {
+		t.Errorf("exportfs calls = %v, want %v", fake.calls, wantCalls)
+	}
+}
+
+func Test_Batch_Commit_rollback(t *testing.T) {
+	fake := newFakeExportfs(
+		Export{"/srv/a", []ClientExport{{Host("10.0.0.1"), []Option{RW}}}},
+		Export{"/srv/b", []ClientExport{{Host("10.0.0.1"), nil}}},
+	)
+	n := NFSManager()
+	n.commandRetrier = fake.run
+
+	before, err := n.ListExports()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake.failOn = "/srv/fail"
+	err = n.Batch().
+		ExportFs("/srv/a", Host("10.0.0.1"), RO).
+		ExportFs("/srv/c", Host("10.0.0.1")).
+		UnExportFs("/srv/c", Host("10.0.0.1")).
+		ExportFs("/srv/d", Host("10.0.0.1")).
+		UnExportFs("/srv/b", Host("10.0.0.1")).
+		ExportFs("/srv/fail", Host("10.0.0.1")).
+		Commit()
+	var rollbackErr *RollbackError
+	if !errors.As(err, &rollbackErr) || len(rollbackErr.RollbackErrors) != 0 {
+		t.Fatalf("Batch.Commit() error = %v, want complete rollback", err)
+	}
+
+	after, err := n.ListExports()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) {
+		t.Fatalf("exports after rollback = %v, want %v", after, before)
+	}
+	for i := range before {
+		if after[i].Path != before[i].Path || !equivalentOptions(after[i].Clients[0].Options, before[i].Clients[0].Options) {
+			t.Errorf("export after rollback = %v, want %v", after[i], before[i])
+		}
+	}
+
+	wantRollback := [][]string{
+		exportFSCommandLine("/srv/b", Host("10.0.0.1"), before[1].Clients[0].Options),
+		unExportFSCommandLine("/srv/d", Host("10.0.0.1")),
+		exportFSCommandLine("/srv/a", Host("10.0.0.1"), before[0].Clients[0].Options),
+	}
+	if got := fake.calls[6:]; !reflect.DeepEqual(got, wantRollback) {
+		t.Errorf("rollback calls = %v, want %v", got, wantRollback)
+	}
+}
+
+func Test_Batch_Commit_invalid(t *testing.T) {
+	fake := newFakeExportfs()
+	n := NFSManager()
+	n.commandRetrier = fake.run
+
+	err := n.Batch().
+		ExportFs("/srv/a", Host("10.0.0.1")).
+		ExportFs("/srv/b", Host("10.0.0.1"), RW, RO).
+		Commit()
+	if err == nil {
+		t.Fatalf("Batch.Commit() error = nil, want error")
+	}
+	if len(fake.calls) != 0 {
+		t.Errorf("exportfs calls = %v, want none", fake.calls)
+	}
+}
+
+func Test_Batch_persistentReloadFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nfsmanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "myapp.exports")
+	want := "# Managed by nfsmanager for myapp. Do not edit.\n/srv/a 10.0.0.1\n"
+	if err := ioutil.WriteFile(path, []byte(want), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reloads := 0
+	n := persistentTestManager(t, dir, &reloads, fmt.Errorf("Mock failure"))
+	err = n.Batch().UnExportFs("/srv/a", Host("10.0.0.1")).ExportFs("/srv/b", Host("10.0.0.1")).Commit()
+	var rollbackErr *RollbackError
+	if !errors.As(err, &rollbackErr) {
+		t.Fatalf("Batch.Commit() error = %v, want a *RollbackError", err)
+	}
+	if got, _ := ioutil.ReadFile(path); string(got) != want {
+		t.Errorf("managed file = %q, want it restored to %q", got, want)
+	}
+	if reloads != 2 {
+		t.Errorf("exportfs -ra ran %d times, want 2", reloads)
+	}
+}
+
+func Test_Batch_persistentCommit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nfsmanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	reloads := 0
+	n := persistentTestManager(t, dir, &reloads, nil)
+	path := filepath.Join(dir, "myapp.exports")
+
+	if err := n.Batch().ExportFs("/srv/a", Host("10.0.0.1")).ExportFs("/srv/b", Host("10.0.0.1")).Commit(); err != nil {
+		t.Fatalf("Batch.Commit() error = %v", err)
+	}
+	want := "# Managed by nfsmanager for myapp. Do not edit.\n/srv/a 10.0.0.1\n/srv/b 10.0.0.1\n"
+	if got, _ := ioutil.ReadFile(path); string(got) != want {
+		t.Errorf("managed file = %q, want %q", got, want)
+	}
+
+	err = n.Batch().UnExportFs("/srv/a", Host("10.0.0.1")).UnExportFs("/srv/c", Host("10.0.0.1")).Commit()
+	if err == nil {
+		t.Fatalf("Batch.Commit() error = nil, want error")
+	}
+	if got, _ := ioutil.ReadFile(path); string(got) != want {
+		t.Errorf("managed file = %q, want it unchanged %q", got, want)
+	}
+	if reloads != 1 {
+		t.Errorf("exportfs -ra ran %d times, want 1", reloads)
+	}
+}
@@ -0,0 +1,228 @@
+package nfsmanager
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ClientKind is the kind of machines a Client specification matches.
+type ClientKind int
+
+const (
+	// ClientHost is a single host, given by name or IP address.
+	ClientHost ClientKind = iota
+	// ClientNetwork is an IPv4 or IPv6 network in CIDR notation, or an
+	// IPv4 network given as address/netmask.
+	ClientNetwork
+	// ClientNetgroup is an NIS netgroup, written as @group.
+	ClientNetgroup
+	// ClientWildcard is a host name pattern using *, ? and [] as in
+	// shell globs, e.g. *.example.com.
+	ClientWildcard
+	// ClientAnonymous matches every client.
+	ClientAnonymous
+)
+
+func (k ClientKind) String() string {
+	switch k {
+	case ClientHost:
+		return "host"
+	case ClientNetwork:
+		return "network"
+	case ClientNetgroup:
+		return "netgroup"
+	case ClientWildcard:
+		return "wildcard"
+	case ClientAnonymous:
+		return "anonymous"
+	default:
+		return fmt.Sprintf("ClientKind(%d)", int(k))
+	}
+}
+
+// Client specifies the machines a path is exported to. Clients are
+// created with Host, Network, Netgroup, Wildcard, Anonymous or
+// ParseClient, and can be compared with ==.
+type Client struct {
+	kind ClientKind
+	spec string
+}
+
+// Anonymous matches every client. It is written as * in exports(5).
+var Anonymous = Client{kind: ClientAnonymous, spec: "*"}
+
+// Host is a single host given by its name or IPv4 or IPv6 address.
+// Addresses are normalized, e.g. Host("FE80:0::1") is fe80::1.
+func Host(name string) Client {
+	if ip := net.ParseIP(name); ip != nil {
+		name = ip.String()
+	}
+	return Client{kind: ClientHost, spec: name}
+}
+
+// Network is an IPv4 or IPv6 network, e.g. "10.0.0.0/8", "fd00::/64"
+// or "10.0.0.0/255.0.0.0".
+func Network(cidr string) Client {
+	return Client{kind: ClientNetwork, spec: cidr}
+}
+
+// Netgroup is an NIS netgroup. The leading @ is optional.
+func Netgroup(name string) Client {
+	return Client{kind: ClientNetgroup, spec: "@" + strings.TrimPrefix(name, "@")}
+}
+
+// Wildcard is a host name pattern, e.g. "*.example.com" or
+// "node-??.example.com".
+func Wildcard(pattern string) Client {
+	return Client{kind: ClientWildcard, spec: pattern}
+}
+
+// ParseClient parses a client specification as written in exports(5)
+// or on the exportfs command line, where IPv6 addresses may be given in
+// brackets.
+func ParseClient(s string) (Client, error) {
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") && strings.Contains(s, ":") {
+		return ParseClient(s[1 : len(s)-1])
+	}
+
+	var c Client
+	switch {
+	case s == "*" || s == "<world>":
+		c = Anonymous
+	case strings.HasPrefix(s, "@"):
+		c = Netgroup(s)
+	case strings.ContainsAny(s, "*?["):
+		c = Wildcard(s)
+	case strings.Contains(s, "/"):
+		c = Network(s)
+	default:
+		c = Host(s)
+	}
+	if err := c.Validate(); err != nil {
+		return Client{}, err
+	}
+	return c, nil
+}
+
+// Kind returns the kind of client c is.
+func (c Client) Kind() ClientKind {
+	return c.kind
+}
+
+// String returns c as written in exports(5).
+func (c Client) String() string {
+	return c.spec
+}
+
+// MarshalText returns c as written in exports(5).
+func (c Client) MarshalText() ([]byte, error) {
+	return []byte(c.spec), nil
+}
+
+// UnmarshalText parses a client the way ParseClient does.
+func (c *Client) UnmarshalText(text []byte) error {
+	parsed, err := ParseClient(string(text))
+	if err != nil {
+		return err
+	}
+	*c = parsed
+	return nil
+}
+
+// commandLineString returns c as written on the exportfs command line,
+// where IPv6 addresses are bracketed to set them apart from the path.
+func (c Client) commandLineString() string {
+	if (c.kind == ClientHost || c.kind == ClientNetwork) && strings.Contains(c.spec, ":") {
+		return "[" + c.spec + "]"
+	}
+	return c.spec
+}
+
+// Validate reports whether c is a well formed client specification.
+func (c Client) Validate() error {
+	if c.spec == "" {
+		return fmt.Errorf("empty client")
+	}
+	if strings.ContainsAny(c.spec, " \t\n(),#\"") {
+		return fmt.Errorf("client %q contains invalid characters", c.spec)
+	}
+
+	switch c.kind {
+	case ClientHost:
+		if net.ParseIP(c.spec) != nil || validHostname(c.spec) {
+			return nil
+		}
+		return fmt.Errorf("%q is neither a host name nor an IP address", c.spec)
+	case ClientNetwork:
+		return validateNetwork(c.spec)
+	case ClientNetgroup:
+		if len(c.spec) == 1 {
+			return fmt.Errorf("empty netgroup")
+		}
+		return nil
+	case ClientWildcard:
+		if !strings.ContainsAny(c.spec, "*?[") {
+			return fmt.Errorf("wildcard %q has no wildcard characters", c.spec)
+		}
+		if !validHostname(strings.NewReplacer("*", "a", "?", "a", "[", "a", "]", "a", "!", "a").Replace(c.spec)) {
+			return fmt.Errorf("wildcard %q is not a host name pattern", c.spec)
+		}
+		return nil
+	case ClientAnonymous:
+		return nil
+	default:
+		return fmt.Errorf("unknown client kind %v", c.kind)
+	}
+}
+
+func validateNetwork(spec string) error {
+	i := strings.Index(spec, "/")
+	if i < 0 {
+		return fmt.Errorf("network %q has no prefix length or netmask", spec)
+	}
+	addr, mask := spec[:i], spec[i+1:]
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return fmt.Errorf("network %q: invalid address %q", spec, addr)
+	}
+
+	bits := 128
+	if ip.To4() != nil {
+		bits = 32
+	}
+	if prefix, err := strconv.Atoi(mask); err == nil {
+		if prefix < 0 || prefix > bits {
+			return fmt.Errorf("network %q: prefix length out of range", spec)
+		}
+		return nil
+	}
+
+	maskIP := net.ParseIP(mask).To4()
+	if bits != 32 || maskIP == nil {
+		return fmt.Errorf("network %q: invalid netmask %q", spec, mask)
+	}
+	if ones, size := net.IPMask(maskIP).Size(); ones == 0 && size == 0 {
+		return fmt.Errorf("network %q: netmask %q is not contiguous", spec, mask)
+	}
+	return nil
+}
+
+func validHostname(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
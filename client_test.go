@@ -0,0 +1,105 @@
+package nfsmanager
+
+import (
+	"testing"
+)
+
+func TestParseClient(t *testing.T) {
+	tests := []struct {
+		name     string
+		s        string
+		want     Client
+		wantKind ClientKind
+		wantErr  bool
+	}{
+		{"Host name", "nfs.example.com", Host("nfs.example.com"), ClientHost, false},
+		{"Short host name", "admin", Host("admin"), ClientHost, false},
+		{"IPv4 address", "10.0.0.1", Host("10.0.0.1"), ClientHost, false},
+		{"IPv6 address", "fe80::1", Host("fe80::1"), ClientHost, false},
+		{"Bracketed IPv6 address", "[fe80::1]", Host("fe80::1"), ClientHost, false},
+		{"IPv6 address is normalized", "FE80:0:0::1", Host("fe80::1"), ClientHost, false},
+		{"IPv4 network", "10.0.0.0/8", Network("10.0.0.0/8"), ClientNetwork, false},
+		{"IPv4 network with netmask", "10.0.0.0/255.0.0.0", Network("10.0.0.0/255.0.0.0"), ClientNetwork, false},
+		{"IPv6 network", "fd00::/64", Network("fd00::/64"), ClientNetwork, false},
+		{"Bracketed IPv6 network", "[fd00::/64]", Network("fd00::/64"), ClientNetwork, false},
+		{"Netgroup", "@trusted", Netgroup("trusted"), ClientNetgroup, false},
+		{"Wildcard", "*.example.com", Wildcard("*.example.com"), ClientWildcard, false},
+		{"Wildcard with ?", "node-??.example.com", Wildcard("node-??.example.com"), ClientWildcard, false},
+		{"Wildcard with character class", "node[0-9].example.com", Wildcard("node[0-9].example.com"), ClientWildcard, false},
+		{"Anonymous", "*", Anonymous, ClientAnonymous, false},
+		{"World", "<world>", Anonymous, ClientAnonymous, false},
+		{"Empty", "", Client{}, ClientHost, true},
+		{"Empty netgroup", "@", Client{}, ClientHost, true},
+		{"Invalid host name", "-bad-.example.com", Client{}, ClientHost, true},
+		{"Host with space", "bad host", Client{}, ClientHost, true},
+		{"Network prefix too long", "10.0.0.0/33", Client{}, ClientHost, true},
+		{"IPv6 network prefix too long", "fd00::/129", Client{}, ClientHost, true},
+		{"Network with bad address", "10.0.0/8", Client{}, ClientHost, true},
+		{"Network with non-contiguous netmask", "10.0.0.0/255.0.255.0", Client{}, ClientHost, true},
+		{"IPv6 network with netmask", "fd00::/ffff::", Client{}, ClientHost, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseClient(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseClient() = %v, want %v", got, tt.want)
+			}
+			if got.Kind() != tt.wantKind {
+				t.Errorf("ParseClient().Kind() = %v, want %v", got.Kind(), tt.wantKind)
+			}
+		})
+	}
+}
+
+func TestClient_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		client  Client
+		wantErr bool
+	}{
+		{"Zero value", Client{}, true},
+		{"Host", Host("nfs.example.com"), false},
+		{"Host that is a pattern", Host("*.example.com"), true},
+		{"Network without prefix", Network("10.0.0.0"), true},
+		{"Wildcard without wildcard", Wildcard("nfs.example.com"), true},
+		{"Wildcard with parentheses", Wildcard("*(rw)"), true},
+		{"Netgroup without @", Netgroup("trusted"), false},
+		{"Anonymous", Anonymous, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.client.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Client.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClient_String(t *testing.T) {
+	tests := []struct {
+		name            string
+		client          Client
+		want            string
+		wantCommandLine string
+	}{
+		{"Host", Host("nfs.example.com"), "nfs.example.com", "nfs.example.com"},
+		{"IPv4 host", Host("10.0.0.1"), "10.0.0.1", "10.0.0.1"},
+		{"IPv6 host", Host("fe80::1"), "fe80::1", "[fe80::1]"},
+		{"IPv6 network", Network("fd00::/64"), "fd00::/64", "[fd00::/64]"},
+		{"Netgroup", Netgroup("@trusted"), "@trusted", "@trusted"},
+		{"Anonymous", Anonymous, "*", "*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.client.String(); got != tt.want {
+				t.Errorf("Client.String() = %v, want %v", got, tt.want)
+			}
+			if got := tt.client.commandLineString(); got != tt.wantCommandLine {
+				t.Errorf("Client.commandLineString() = %v, want %v", got, tt.wantCommandLine)
+			}
+		})
+	}
+}
@@ -0,0 +1,437 @@
+// Command nfsmanager manages NFS exports from the shell with the same
+// semantics the nfsmanager library gives Go programs.
+//
+// Usage:
+//
+//	nfsmanager export [flags] PATH CLIENT
+//	nfsmanager unexport [flags] PATH CLIENT
+//	nfsmanager list [flags]
+//	nfsmanager apply [flags] -f FILE
+//	nfsmanager diff [flags] -f FILE
+//	nfsmanager validate [flags] [-f FILE] [--opt OPTION]...
+//	nfsmanager reload [flags]
+//
+// FILE describes the desired exports, either as a YAML or JSON spec
+// (see nfsmanager.Spec) if its name ends in .yaml, .yml or .json, or in
+// exports(5) format otherwise. A spec's owner persists its exports as
+// --owner does. diff exits with status 1 if applying FILE would change
+// anything, and validate if FILE or the options have problems.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sorenisanerd/nfsmanager"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// exitUsage is the exit status for invalid command lines and failures.
+// Status 1 is reserved for diff and validate finding something.
+const exitUsage = 2
+
+type command struct {
+	name  string
+	usage string
+	run   func(c *cli, args []string) (int, error)
+}
+
+var commands = []command{
+	{"export", "export [flags] PATH CLIENT", (*cli).export},
+	{"unexport", "unexport [flags] PATH CLIENT", (*cli).unexport},
+	{"list", "list [flags]", (*cli).list},
+	{"apply", "apply [flags] -f FILE", (*cli).apply},
+	{"diff", "diff [flags] -f FILE", (*cli).diff},
+	{"validate", "validate [flags] [-f FILE] [--opt OPTION]...", (*cli).validate},
+	{"reload", "reload [flags]", (*cli).reload},
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return exitUsage
+	}
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+		c := &cli{stdout: stdout, stderr: stderr}
+		c.flags = flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+		c.flags.SetOutput(stderr)
+		c.flags.Usage = func() {
+			fmt.Fprintf(stderr, "usage: nfsmanager %s\n", cmd.usage)
+			c.flags.PrintDefaults()
+		}
+		c.flags.BoolVar(&c.dryRun, "dry-run", false, "print the commands and file writes instead of making them")
+		c.flags.BoolVar(&c.json, "json", false, "print output as JSON")
+		c.flags.StringVar(&c.owner, "owner", "", "persist exports to /etc/exports.d/OWNER.exports instead of only exporting them")
+		c.flags.StringVar(&c.privilege, "privilege", "sudo", "how to retry commands that lack privileges: sudo, doas, pkexec or none")
+		c.flags.StringVar(&c.file, "f", "", "`FILE` with the desired exports, a YAML or JSON spec or in exports(5) format")
+		c.flags.Var(&c.options, "opt", "export `OPTION`, e.g. rw or fsid=7; may be repeated")
+
+		status, err := cmd.run(c, args[1:])
+		if err != nil {
+			if err != flag.ErrHelp {
+				fmt.Fprintf(stderr, "nfsmanager %s: %v\n", cmd.name, err)
+			}
+			return exitUsage
+		}
+		return status
+	}
+	usage(stderr)
+	return exitUsage
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  nfsmanager %s\n", cmd.usage)
+	}
+}
+
+// optionsFlag collects --opt values. Each may hold several comma
+// separated options.
+type optionsFlag []nfsmanager.Option
+
+func (o *optionsFlag) String() string {
+	var s []string
+	for _, opt := range *o {
+		s = append(s, opt.String())
+	}
+	return strings.Join(s, ",")
+}
+
+func (o *optionsFlag) Set(value string) error {
+	options, err := nfsmanager.ParseOptions(value)
+	if err != nil {
+		return err
+	}
+	*o = append(*o, options...)
+	return nil
+}
+
+type cli struct {
+	stdout, stderr io.Writer
+	flags          *flag.FlagSet
+
+	dryRun    bool
+	json      bool
+	owner     string
+	privilege string
+	file      string
+	options   optionsFlag
+
+	plan *nfsmanager.Plan
+}
+
+// parse parses the command's flags and returns its nargs positional
+// arguments.
+func (c *cli) parse(args []string, nargs int) ([]string, error) {
+	if err := c.flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if c.flags.NArg() != nargs {
+		c.flags.Usage()
+		return nil, fmt.Errorf("expected %d arguments, got %d", nargs, c.flags.NArg())
+	}
+	return c.flags.Args(), nil
+}
+
+// manager returns the manager the flags ask for.
+func (c *cli) manager() (*nfsmanager.Manager, error) {
+	n := nfsmanager.NFSManager()
+	switch c.privilege {
+	case "sudo":
+		n.Privilege = nfsmanager.Sudo{}
+	case "doas":
+		n.Privilege = nfsmanager.Doas{}
+	case "pkexec":
+		n.Privilege = nfsmanager.Pkexec{}
+	case "none":
+		n.Privilege = nfsmanager.NoEscalation
+	default:
+		return nil, fmt.Errorf("unknown privilege %q", c.privilege)
+	}
+	if c.owner != "" {
+		var err error
+		if n, err = n.Persistent(c.owner); err != nil {
+			return nil, err
+		}
+	}
+	if c.dryRun {
+		n, c.plan = n.DryRun()
+	}
+	return n, nil
+}
+
+// readFile reads the desired exports from the -f flag.
+func (c *cli) readFile() (*nfsmanager.Spec, error) {
+	if c.file == "" {
+		c.flags.Usage()
+		return nil, errors.New("-f is required")
+	}
+	switch strings.ToLower(filepath.Ext(c.file)) {
+	case ".yaml", ".yml", ".json":
+		return nfsmanager.LoadSpec(c.file)
+	}
+	f, err := nfsmanager.ReadExportsFile(c.file)
+	if err != nil {
+		return nil, err
+	}
+	return &nfsmanager.Spec{Exports: f.Exports()}, nil
+}
+
+func (c *cli) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// printPlan prints the plan of a dry run.
+func (c *cli) printPlan() error {
+	if c.plan == nil {
+		return nil
+	}
+	if c.json {
+		return c.printJSON(map[string][]string{"plan": c.planSteps()})
+	}
+	for _, step := range c.plan.Steps {
+		fmt.Fprintln(c.stdout, step)
+	}
+	return nil
+}
+
+func (c *cli) planSteps() []string {
+	steps := []string{}
+	for _, step := range c.plan.Steps {
+		steps = append(steps, step.String())
+	}
+	return steps
+}
+
+// printChanges prints the changes Apply made, along with the plan of a
+// dry run when printing JSON.
+func (c *cli) printChanges(report nfsmanager.ChangeReport) error {
+	if c.json {
+		changes := report.Changes
+		if changes == nil {
+			changes = []nfsmanager.Change{}
+		}
+		out := map[string]interface{}{"changes": changes}
+		if c.plan != nil {
+			out["plan"] = c.planSteps()
+		}
+		return c.printJSON(out)
+	}
+	for _, change := range report.Changes {
+		fmt.Fprintln(c.stdout, change)
+	}
+	return nil
+}
+
+func (c *cli) export(args []string) (int, error) {
+	args, err := c.parse(args, 2)
+	if err != nil {
+		return 0, err
+	}
+	client, err := nfsmanager.ParseClient(args[1])
+	if err != nil {
+		return 0, err
+	}
+	n, err := c.manager()
+	if err != nil {
+		return 0, err
+	}
+	if err := n.ExportFs(args[0], client, c.options...); err != nil {
+		return 0, err
+	}
+	return 0, c.printPlan()
+}
+
+func (c *cli) unexport(args []string) (int, error) {
+	args, err := c.parse(args, 2)
+	if err != nil {
+		return 0, err
+	}
+	client, err := nfsmanager.ParseClient(args[1])
+	if err != nil {
+		return 0, err
+	}
+	n, err := c.manager()
+	if err != nil {
+		return 0, err
+	}
+	if err := n.UnExportFs(args[0], client); err != nil {
+		return 0, err
+	}
+	return 0, c.printPlan()
+}
+
+// list prints the active exports, or those in the managed file with
+// --owner, in exports(5) format.
+func (c *cli) list(args []string) (int, error) {
+	if _, err := c.parse(args, 0); err != nil {
+		return 0, err
+	}
+	n, err := c.manager()
+	if err != nil {
+		return 0, err
+	}
+	var exports []nfsmanager.Export
+	if c.owner != "" {
+		exports, err = n.PersistedExports()
+	} else {
+		exports, err = n.ListExports()
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if c.json {
+		if exports == nil {
+			exports = []nfsmanager.Export{}
+		}
+		return 0, c.printJSON(exports)
+	}
+	f := &nfsmanager.ExportsFile{}
+	for _, export := range exports {
+		f.Set(export)
+	}
+	_, err = f.WriteTo(c.stdout)
+	return 0, err
+}
+
+func (c *cli) apply(args []string) (int, error) {
+	if _, err := c.parse(args, 0); err != nil {
+		return 0, err
+	}
+	spec, err := c.readFile()
+	if err != nil {
+		return 0, err
+	}
+	n, err := c.manager()
+	if err != nil {
+		return 0, err
+	}
+	report, err := n.ApplySpec(spec)
+	if perr := c.printChanges(report); perr != nil && err == nil {
+		err = perr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !c.json {
+		return 0, c.printPlan()
+	}
+	return 0, nil
+}
+
+// diff prints the changes apply would make, without making them.
+func (c *cli) diff(args []string) (int, error) {
+	c.dryRun = true
+	if _, err := c.parse(args, 0); err != nil {
+		return 0, err
+	}
+	spec, err := c.readFile()
+	if err != nil {
+		return 0, err
+	}
+	n, err := c.manager()
+	if err != nil {
+		return 0, err
+	}
+	report, err := n.ApplySpec(spec)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.printChanges(report); err != nil {
+		return 0, err
+	}
+	if !report.Empty() {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// validate checks the --opt options and the exports in -f without
+// touching the export table.
+func (c *cli) validate(args []string) (int, error) {
+	if _, err := c.parse(args, 0); err != nil {
+		return 0, err
+	}
+	if c.file == "" && len(c.options) == 0 {
+		c.flags.Usage()
+		return 0, errors.New("nothing to validate; give -f or --opt")
+	}
+
+	var problems []string
+	var exports []nfsmanager.Export
+	if len(c.options) > 0 {
+		if err := nfsmanager.ValidateOptions(c.options...); err != nil {
+			problems = append(problems, fmt.Sprintf("--opt: %v", err))
+		}
+	}
+	if c.file != "" {
+		spec, err := c.readFile()
+		var specErr *nfsmanager.SpecError
+		if errors.As(err, &specErr) {
+			for _, problem := range specErr.Problems {
+				problems = append(problems, fmt.Sprintf("%s:%s", c.file, problem))
+			}
+		} else if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", c.file, err))
+		} else {
+			exports = spec.Exports
+		}
+		for _, export := range exports {
+			for _, client := range export.Clients {
+				if err := client.Client.Validate(); err != nil {
+					problems = append(problems, fmt.Sprintf("%s: %s %s: %v", c.file, export.Path, client.Client, err))
+				}
+				if err := nfsmanager.ValidateOptions(client.Options...); err != nil {
+					problems = append(problems, fmt.Sprintf("%s: %s %s: %v", c.file, export.Path, client.Client, err))
+				}
+			}
+		}
+	}
+
+	if c.json {
+		if problems == nil {
+			problems = []string{}
+		}
+		if err := c.printJSON(map[string][]string{"problems": problems}); err != nil {
+			return 0, err
+		}
+	} else {
+		for _, problem := range problems {
+			fmt.Fprintln(c.stdout, problem)
+		}
+	}
+	if len(problems) > 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (c *cli) reload(args []string) (int, error) {
+	if _, err := c.parse(args, 0); err != nil {
+		return 0, err
+	}
+	n, err := c.manager()
+	if err != nil {
+		return 0, err
+	}
+	if err := n.Reload(); err != nil {
+		return 0, err
+	}
+	return 0, c.printPlan()
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeExportfs puts an exportfs script on PATH that logs its arguments
+// to dir/calls and prints dir/table for exportfs -v.
+func fakeExportfs(t *testing.T, table string) (dir string, restore func()) {
+	dir, err := ioutil.TempDir("", "nfsmanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\n" +
+		"echo \"$@\" >> " + filepath.Join(dir, "calls") + "\n" +
+		"if [ \"$1\" = -v ]; then cat " + filepath.Join(dir, "table") + "; fi\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "exportfs"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "table"), []byte(table), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	return dir, func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+func calls(t *testing.T, dir string) string {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "calls"))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func runCLI(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	status := run(append(args[:1:1], append([]string{"--privilege", "none"}, args[1:]...)...), &stdout, &stderr)
+	return status, stdout.String(), stderr.String()
+}
+
+func TestExportAndUnexport(t *testing.T) {
+	dir, restore := fakeExportfs(t, "")
+	defer restore()
+
+	if status, _, stderr := runCLI("export", "--opt", "rw", "--opt", "fsid=7,no_root_squash", "/srv/a", "10.0.0.1"); status != 0 {
+		t.Fatalf("export exited %d: %s", status, stderr)
+	}
+	if status, _, stderr := runCLI("unexport", "/srv/a", "10.0.0.1"); status != 0 {
+		t.Fatalf("unexport exited %d: %s", status, stderr)
+	}
+	want := "10.0.0.1:/srv/a -o rw,fsid=7,no_root_squash\n-u 10.0.0.1:/srv/a\n"
+	if got := calls(t, dir); got != want {
+		t.Errorf("exportfs calls = %q, want %q", got, want)
+	}
+
+	if status, _, _ := runCLI("export", "--opt", "bogus", "/srv/a", "10.0.0.1"); status != exitUsage {
+		t.Errorf("export with an unknown option exited %d, want %d", status, exitUsage)
+	}
+	if status, _, _ := runCLI("export", "/srv/a"); status != exitUsage {
+		t.Errorf("export without a client exited %d, want %d", status, exitUsage)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	dir, restore := fakeExportfs(t, "")
+	defer restore()
+
+	status, stdout, stderr := runCLI("export", "--dry-run", "--json", "--opt", "ro", "/srv/a", "*")
+	if status != 0 {
+		t.Fatalf("export --dry-run exited %d: %s", status, stderr)
+	}
+	var out struct{ Plan []string }
+	if err := json.Unmarshal([]byte(stdout), &out); err != nil {
+		t.Fatalf("export --dry-run --json printed %q: %v", stdout, err)
+	}
+	if len(out.Plan) != 1 || out.Plan[0] != "exportfs *:/srv/a -o ro" {
+		t.Errorf("export --dry-run --json plan = %q", out.Plan)
+	}
+	if got := calls(t, dir); got != "" {
+		t.Errorf("exportfs calls = %q, want none", got)
+	}
+}
+
+func TestListApplyDiff(t *testing.T) {
+	dir, restore := fakeExportfs(t, "/srv/a\t10.0.0.1(sync,wdelay,hide,no_subtree_check,sec=sys,rw,secure,root_squash,no_all_squash)\n")
+	defer restore()
+
+	status, stdout, stderr := runCLI("list")
+	if status != 0 {
+		t.Fatalf("list exited %d: %s", status, stderr)
+	}
+	if want := "/srv/a 10.0.0.1(sync,wdelay,hide,no_subtree_check,sec=sys,rw,secure,root_squash,no_all_squash)\n"; stdout != want {
+		t.Errorf("list printed %q, want %q", stdout, want)
+	}
+
+	status, stdout, _ = runCLI("list", "--json")
+	var exports []struct {
+		Path    string
+		Clients []struct {
+			Client  string
+			Options []string
+		}
+	}
+	if err := json.Unmarshal([]byte(stdout), &exports); err != nil || status != 0 {
+		t.Fatalf("list --json exited %d and printed %q: %v", status, stdout, err)
+	}
+	if len(exports) != 1 || exports[0].Clients[0].Client != "10.0.0.1" || len(exports[0].Clients[0].Options) != 9 {
+		t.Errorf("list --json = %+v", exports)
+	}
+
+	file := filepath.Join(dir, "exports")
+	if err := ioutil.WriteFile(file, []byte("/srv/a 10.0.0.1(rw)\n/srv/b *(ro)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	status, stdout, stderr = runCLI("diff", "-f", file)
+	if status != 1 || stdout != "export *:/srv/b(ro)\n" {
+		t.Errorf("diff exited %d and printed %q (%s), want 1 and the export of /srv/b", status, stdout, stderr)
+	}
+	if got := calls(t, dir); strings.Trim(strings.Replace(got, "-v\n", "", -1), "\n") != "" {
+		t.Errorf("exportfs calls after diff = %q, want only listings", got)
+	}
+
+	status, stdout, stderr = runCLI("apply", "-f", file)
+	if status != 0 || stdout != "export *:/srv/b(ro)\n" {
+		t.Errorf("apply exited %d and printed %q (%s)", status, stdout, stderr)
+	}
+	if got := calls(t, dir); !strings.HasSuffix(got, "*:/srv/b -o ro\n") {
+		t.Errorf("exportfs calls after apply = %q", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir, restore := fakeExportfs(t, "")
+	defer restore()
+
+	file := filepath.Join(dir, "exports")
+	if err := ioutil.WriteFile(file, []byte("/srv/a 10.0.0.1(rw,ro)\n/srv/b *(ro)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	status, stdout, _ := runCLI("validate", "-f", file)
+	if status != 1 || !strings.Contains(stdout, "/srv/a 10.0.0.1") || strings.Contains(stdout, "/srv/b") {
+		t.Errorf("validate exited %d and printed %q, want 1 and a problem with /srv/a", status, stdout)
+	}
+	if status, stdout, _ := runCLI("validate", "--opt", "rw,sync"); status != 0 || stdout != "" {
+		t.Errorf("validate --opt rw,sync exited %d and printed %q, want 0 and nothing", status, stdout)
+	}
+	if status, _, _ := runCLI("validate"); status != exitUsage {
+		t.Errorf("validate without input exited %d, want %d", status, exitUsage)
+	}
+}
+
+func TestSpecFiles(t *testing.T) {
+	dir, restore := fakeExportfs(t, "")
+	defer restore()
+
+	spec := filepath.Join(dir, "exports.yaml")
+	data := "exports:\n  - path: /srv/a\n    clients:\n      - client: 10.0.0.1\n        options: [rw]\n"
+	if err := ioutil.WriteFile(spec, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	status, stdout, stderr := runCLI("diff", "-f", spec)
+	if status != 1 || stdout != "export 10.0.0.1:/srv/a(rw)\n" {
+		t.Errorf("diff exited %d and printed %q (%s)", status, stdout, stderr)
+	}
+
+	if err := ioutil.WriteFile(spec, []byte(data+"      - client: 10.0.0.2\n        options: [rw, ro]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	status, stdout, _ = runCLI("validate", "-f", spec)
+	if want := spec + ":7:18: exports[0].clients[1].options: invalid options: ro contradicts rw\n"; status != 1 || stdout != want {
+		t.Errorf("validate exited %d and printed %q, want 1 and %q", status, stdout, want)
+	}
+}
@@ -0,0 +1,147 @@
+package nfsmanager
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// ErrorCause classifies why a command failed.
+type ErrorCause int
+
+const (
+	// CauseUnknown is used when the failure matches none of the other
+	// causes.
+	CauseUnknown ErrorCause = iota
+	// CausePermissionDenied means the command lacked the privileges to
+	// change the export table.
+	CausePermissionDenied
+	// CauseNoSuchPath means the exported path does not exist.
+	CauseNoSuchPath
+	// CauseInvalidOption means an export option was rejected.
+	CauseInvalidOption
+	// CauseNFSDNotRunning means the NFS server is not running.
+	CauseNFSDNotRunning
+	// CauseUnknownHost means the client could not be resolved.
+	CauseUnknownHost
+)
+
+func (c ErrorCause) String() string {
+	switch c {
+	case CausePermissionDenied:
+		return "permission denied"
+	case CauseNoSuchPath:
+		return "no such path"
+	case CauseInvalidOption:
+		return "invalid option"
+	case CauseNFSDNotRunning:
+		return "nfsd not running"
+	case CauseUnknownHost:
+		return "unknown host"
+	default:
+		return "unknown"
+	}
+}
+
+// causePatterns maps causes to the messages exportfs, sudo, systemctl
+// and the kernel report them with. They are matched case insensitively, in
+// order.
+var causePatterns = []struct {
+	cause    ErrorCause
+	patterns []string
+}{
+	{CausePermissionDenied, []string{"permission denied", "operation not permitted", "errno 13", "a password is required", "must be root", "access denied", "interactive authentication required", "not authorized"}},
+	{CauseNoSuchPath, []string{"no such file or directory", "failed to stat"}},
+	{CauseInvalidOption, []string{"unknown keyword", "bad option", "invalid option", "syntax error", "invalid value"}},
+	{CauseUnknownHost, []string{"failed to resolve", "unknown host", "name or service not known", "does not resolve", "no address associated"}},
+	{CauseNFSDNotRunning, []string{"nfsd not running", "rpc.nfsd", "function not implemented", "/proc/fs/nfsd"}},
+}
+
+func classifyOutput(output []byte) ErrorCause {
+	text := strings.ToLower(string(output))
+	for _, cp := range causePatterns {
+		for _, pattern := range cp.patterns {
+			if strings.Contains(text, pattern) {
+				return cp.cause
+			}
+		}
+	}
+	return CauseUnknown
+}
+
+// exitCode returns the exit code of the process err is about, or -1 if
+// it didn't exit normally.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// ExportError is returned when a command run to query or change the
+// export table fails.
+type ExportError struct {
+	// CommandLine is the command that failed, without any privilege
+	// escalation.
+	CommandLine []string
+	// ExitCode is the exit code of the last attempt, or -1 if it didn't
+	// exit normally, e.g. because it was killed or couldn't be started.
+	ExitCode int
+	// Stdout and Stderr hold the output of the last attempt.
+	Stdout []byte
+	Stderr []byte
+	// Escalated is the command line the command was retried with after
+	// failing for lack of privileges, or nil if it wasn't retried.
+	Escalated []string
+	// Cause classifies the failure based on the command's output.
+	Cause ErrorCause
+	// Err is the underlying error, e.g. an *exec.ExitError or an error
+	// wrapping context.DeadlineExceeded.
+	Err error
+}
+
+func (e *ExportError) Error() string {
+	msg := fmt.Sprintf("Command %v failed", strings.Join(e.CommandLine, " "))
+	if len(e.Escalated) > 0 {
+		msg += fmt.Sprintf(" with %s as well", e.Escalated[0])
+	}
+	if e.Cause != CauseUnknown {
+		msg += fmt.Sprintf(" (%s)", e.Cause)
+	}
+	msg += fmt.Sprintf(": %v", e.Err)
+	if stderr := strings.TrimSpace(string(e.Stderr)); stderr != "" {
+		msg += ": " + stderr
+	}
+	return msg
+}
+
+func (e *ExportError) Unwrap() error {
+	return e.Err
+}
+
+// RollbackError is returned when a change failed part way and the
+// parts already made were rolled back.
+type RollbackError struct {
+	// Err is the failure that caused the rollback.
+	Err error
+	// RollbackErrors are the failures that occurred while rolling back.
+	// If there are none, the rollback was complete.
+	RollbackErrors []error
+}
+
+func (e *RollbackError) Error() string {
+	if len(e.RollbackErrors) == 0 {
+		return fmt.Sprintf("%v (rolled back)", e.Err)
+	}
+	var msgs []string
+	for _, err := range e.RollbackErrors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%v (rollback failed: %s)", e.Err, strings.Join(msgs, "; "))
+}
+
+func (e *RollbackError) Unwrap() error {
+	return e.Err
+}
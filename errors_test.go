@@ -0,0 +1,87 @@
+package nfsmanager
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func Test_classifyOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   ErrorCause
+	}{
+		{"Empty", "", CauseUnknown},
+		{"Lock file", "exportfs: could not open /var/lib/nfs/.etab.lock for locking: errno 13 (Permission denied)", CausePermissionDenied},
+		{"Sudo password", "sudo: a password is required", CausePermissionDenied},
+		{"Systemd access denied", "Failed to start nfs-server.service: Access denied", CausePermissionDenied},
+		{"Polkit", "Failed to start nfs-server.service: Interactive authentication required.", CausePermissionDenied},
+		{"Missing path", "exportfs: Failed to stat /srv/missing: No such file or directory", CauseNoSuchPath},
+		{"Unknown keyword", `exportfs: 10.0.0.1:/srv: unknown keyword "bogus"`, CauseInvalidOption},
+		{"Unresolvable host", "exportfs: Failed to resolve no.such.host", CauseUnknownHost},
+		{"No nfsd", "exportfs: /proc/fs/nfsd is not mounted", CauseNFSDNotRunning},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyOutput([]byte(tt.output)); got != tt.want {
+				t.Errorf("classifyOutput() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_runAndEscalateOnPermissionError_ExportError(t *testing.T) {
+	failWith := func(name string, arg ...string) *exec.Cmd {
+		if name == "sudo" {
+			return exec.Command("sh", "-c", "echo 'sudo: a password is required' >&2; exit 1")
+		}
+		return exec.Command("sh", "-c", "echo out; echo 'exportfs: could not open /var/lib/nfs/.etab.lock for locking: errno 13 (Permission denied)' >&2; exit 3")
+	}
+
+	cmdLine := []string{"exportfs", "10.0.0.1:/srv/locked"}
+	_, err := runAndEscalateOnPermissionError(context.Background(), cmdLine, failWith, Sudo{}, NopLogger)
+
+	var exportErr *ExportError
+	if !errors.As(err, &exportErr) {
+		t.Fatalf("runAndEscalateOnPermissionError() error = %T, want *ExportError", err)
+	}
+	if exportErr.ExitCode != 1 {
+		t.Errorf("ExitCode = %d, want 1", exportErr.ExitCode)
+	}
+	if want := []string{"sudo", "-n", "exportfs", "10.0.0.1:/srv/locked"}; !reflect.DeepEqual(exportErr.Escalated, want) {
+		t.Errorf("Escalated = %v, want %v", exportErr.Escalated, want)
+	}
+	if exportErr.Cause != CausePermissionDenied {
+		t.Errorf("Cause = %v, want %v", exportErr.Cause, CausePermissionDenied)
+	}
+	if got := string(exportErr.Stderr); got != "sudo: a password is required\n" {
+		t.Errorf("Stderr = %q", got)
+	}
+	want := "Command exportfs 10.0.0.1:/srv/locked failed with sudo as well (permission denied): exit status 1: sudo: a password is required"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
+
+func TestExportError_Error(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *ExportError
+		want string
+	}{
+		{"Unknown cause", &ExportError{CommandLine: []string{"exportfs", "-v"}, ExitCode: 1, Err: errors.New("exit status 1")},
+			"Command exportfs -v failed: exit status 1"},
+		{"With stdout kept apart", &ExportError{CommandLine: []string{"exportfs", "-v"}, ExitCode: 2, Stdout: []byte("out"), Stderr: []byte("unknown keyword \"x\"\n"), Cause: CauseInvalidOption, Err: errors.New("exit status 2")},
+			"Command exportfs -v failed (invalid option): exit status 2: unknown keyword \"x\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.want {
+				t.Errorf("ExportError.Error() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
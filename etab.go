@@ -0,0 +1,47 @@
+package nfsmanager
+
+import (
+	"io/ioutil"
+	"path/filepath"
+)
+
+// EtabPath is where exportfs records the exports it has passed to the
+// kernel, with all options expanded.
+const EtabPath = "/var/lib/nfs/etab"
+
+// ProcExportsPath is where the kernel lists the exports in its cache.
+const ProcExportsPath = "/proc/fs/nfs/exports"
+
+// ReadEtab returns the exports recorded in root's /var/lib/nfs/etab. It
+// neither runs exportfs nor needs privileges. An empty root means "/".
+//
+// etab lists each export with every option spelled out, defaults
+// included, the way `exportfs -v` reports them.
+func ReadEtab(root string) ([]Export, error) {
+	content, err := ioutil.ReadFile(rootedPath(root, EtabPath))
+	if err != nil {
+		return nil, err
+	}
+	return parseExportfsVerbose(content)
+}
+
+// ReadProcExports returns the exports in the kernel's export cache as
+// listed by root's /proc/fs/nfs/exports. An empty root means "/".
+//
+// The kernel only lists exports clients have used since the cache was
+// last flushed. The security flavors it reports by number are returned
+// by name.
+func ReadProcExports(root string) ([]Export, error) {
+	content, err := ioutil.ReadFile(rootedPath(root, ProcExportsPath))
+	if err != nil {
+		return nil, err
+	}
+	return parseKernelExports(content)
+}
+
+func rootedPath(root string, name string) string {
+	if root == "" {
+		root = "/"
+	}
+	return filepath.Join(root, name)
+}
@@ -0,0 +1,78 @@
+package nfsmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, root, name, content string) {
+	path := filepath.Join(root, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadEtab(t *testing.T) {
+	root, err := ioutil.TempDir("", "nfsmanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	if _, err := ReadEtab(root); !os.IsNotExist(err) {
+		t.Errorf("ReadEtab() without etab error = %v, want not exist", err)
+	}
+
+	writeTestFile(t, root, "var/lib/nfs/etab",
+		"/srv/a\t10.0.0.1(rw,sync,wdelay,hide,nocrossmnt,secure,no_root_squash,no_all_squash,no_subtree_check,secure_locks,acl,no_pnfs,anonuid=65534,anongid=65534,sec=sys,rw,secure,no_root_squash,no_all_squash)\n"+
+			"/srv/with\\040space\t*(ro,sync,wdelay,hide,nocrossmnt,secure,root_squash,no_all_squash,no_subtree_check,secure_locks,acl,no_pnfs,anonuid=65534,anongid=65534,sec=sys,ro,secure,root_squash,no_all_squash)\n"+
+			"/srv/a\t10.1.0.0/16(ro,sync,wdelay,hide,nocrossmnt,secure,root_squash,no_all_squash,no_subtree_check,secure_locks,acl,no_pnfs,anonuid=65534,anongid=65534,sec=sys,ro,secure,root_squash,no_all_squash)\n")
+
+	exports, err := ReadEtab(root)
+	if err != nil {
+		t.Fatalf("ReadEtab() error = %v", err)
+	}
+	var got [][2]string
+	for _, export := range exports {
+		for _, client := range export.Clients {
+			got = append(got, [2]string{export.Path, client.Client.String()})
+		}
+	}
+	want := [][2]string{{"/srv/a", "10.0.0.1"}, {"/srv/a", "10.1.0.0/16"}, {"/srv/with space", "*"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadEtab() = %v, want %v", got, want)
+	}
+	if !equivalentOptions(exports[0].Clients[0].Options, []Option{RW, NoRootSquash}) {
+		t.Errorf("ReadEtab() options = %v, want rw,no_root_squash with defaults", exports[0].Clients[0].Options)
+	}
+}
+
+func TestReadProcExports(t *testing.T) {
+	root, err := ioutil.TempDir("", "nfsmanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	writeTestFile(t, root, "proc/fs/nfs/exports", "# Version 1.1\n"+
+		"# Path Client(Flags) # IPs\n"+
+		"/srv/a\t10.0.0.1(rw,no_root_squash,sync,wdelay,no_subtree_check,uuid=0123:4567:89ab:cdef,sec=1:390005)\n"+
+		"/srv/b\t*()\n")
+
+	exports, err := ReadProcExports(root)
+	if err != nil {
+		t.Fatalf("ReadProcExports() error = %v", err)
+	}
+	if len(exports) != 1 || exports[0].Path != "/srv/a" || exports[0].Clients[0].Client != Host("10.0.0.1") {
+		t.Fatalf("ReadProcExports() = %v, want /srv/a to 10.0.0.1", exports)
+	}
+	if got := optionsString(exports[0].Clients[0].Options); got != "rw,no_root_squash,sync,wdelay,no_subtree_check,uuid=0123:4567:89ab:cdef,sec=sys:krb5p" {
+		t.Errorf("ReadProcExports() options = %v", got)
+	}
+}
@@ -0,0 +1,94 @@
+package nfsmanager
+
+import (
+	"context"
+	"fmt"
+)
+
+// Exporter changes and lists the export table. *Manager implements it
+// with exportfs, or with its Backend, which is itself an Exporter such
+// as *Kernel. Other implementations can wrap a Manager, e.g. to add
+// caching or metrics, or replace it, like the in-memory fake in package
+// nfstest.
+type Exporter interface {
+	// ExportFs exports path to client with options, replacing the
+	// options if path is already exported to client.
+	ExportFs(path string, client Client, options ...Option) error
+	ExportFsContext(ctx context.Context, path string, client Client, options ...Option) error
+
+	// UnExportFs unexports path from client. It is an error if path
+	// isn't exported to client.
+	UnExportFs(path string, client Client) error
+	UnExportFsContext(ctx context.Context, path string, client Client) error
+
+	// ListExports returns the active exports.
+	ListExports() ([]Export, error)
+	ListExportsContext(ctx context.Context) ([]Export, error)
+
+	// Reload makes the export table match the exports(5) files.
+	Reload() error
+	ReloadContext(ctx context.Context) error
+}
+
+var _ Exporter = (*Manager)(nil)
+
+// dryRunner is implemented by backends that can record the changes they
+// would make in a Plan instead of making them.
+type dryRunner interface {
+	dryRun(plan *Plan) Exporter
+}
+
+// changer returns the Exporter n makes changes through: its Backend, or
+// the version of it that adds them to the plan of a dry-run manager.
+func (n *Manager) changer() (Exporter, error) {
+	if n.plan == nil {
+		return n.Backend, nil
+	}
+	r, ok := n.Backend.(dryRunner)
+	if !ok {
+		return nil, fmt.Errorf("%T does not support dry runs", n.Backend)
+	}
+	return r.dryRun(n.plan), nil
+}
+
+// exportFs exports path to client through n's Backend, or with exportfs
+// if it has none.
+func (n *Manager) exportFs(ctx context.Context, path string, client Client, options []Option) error {
+	if n.Backend == nil {
+		_, err := n.run(ctx, exportFSCommandLine(path, client, options))
+		return err
+	}
+	b, err := n.changer()
+	if err != nil {
+		return err
+	}
+	return b.ExportFsContext(ctx, path, client, options...)
+}
+
+// unExportFs unexports path from client through n's Backend, or with
+// exportfs if it has none.
+func (n *Manager) unExportFs(ctx context.Context, path string, client Client) error {
+	if n.Backend == nil {
+		_, err := n.run(ctx, unExportFSCommandLine(path, client))
+		return err
+	}
+	b, err := n.changer()
+	if err != nil {
+		return err
+	}
+	return b.UnExportFsContext(ctx, path, client)
+}
+
+// reload reloads the export table through n's Backend, or with exportfs
+// if it has none.
+func (n *Manager) reload(ctx context.Context) error {
+	if n.Backend == nil {
+		_, err := n.run(ctx, reloadCommandLine())
+		return err
+	}
+	b, err := n.changer()
+	if err != nil {
+		return err
+	}
+	return b.ReloadContext(ctx)
+}
@@ -1,14 +1,19 @@
 package nfsmanager
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"os/exec"
 	"strings"
-
-	"log"
+	"sync"
+	"time"
 )
 
-type nfsOption struct {
+// Option is an export option as described in exports(5). Options are
+// created with the variables and constructors in this package, or
+// parsed from text with ParseOptions.
+type Option struct {
 	optionString     string
 	extra            []string
 	omitIfExtraEmpty bool
@@ -17,17 +22,28 @@ type nfsOption struct {
 // Secure requires that requests originate on an Internet port less than
 // IPPORT_RESERVED (1024). This option is on by default. To turn it off,
 // specify InSecure.
-var Secure nfsOption = nfsOption{
+var Secure Option = Option{
 	optionString: "secure",
 }
 
+// InSecure allows requests to originate on any Internet port, turning
+// off Secure.
+var InSecure Option = Option{
+	optionString: "insecure",
+}
+
 // RW allows both read and write requests on this NFS volume. The
 // default is to disallow any request which changes the filesystem. This
 // can also be made  explicit by using the RO option.
-var RW nfsOption = nfsOption{
+var RW Option = Option{
 	optionString: "rw",
 }
 
+// RO only allows read requests on this NFS volume. This is the default.
+var RO Option = Option{
+	optionString: "ro",
+}
+
 // ASync allows the NFS server to violate the NFS protocol and reply to
 // requests before any changes made by that request have been committed
 // to stable storage (e.g. disc drive).
@@ -35,7 +51,7 @@ var RW nfsOption = nfsOption{
 // Using this option usually improves performance, but at the cost that
 // an unclean server restart (i.e. a crash) can cause data to be lost or
 // corrupted.
-var ASync nfsOption = nfsOption{
+var ASync Option = Option{
 	optionString: "async",
 }
 
@@ -48,7 +64,7 @@ var ASync nfsOption = nfsOption{
 // TODO: help make system administrators aware of this change, by
 // insisting on either being set.
 // exportfs will issue a. Update this doc per man page once done.
-var Sync nfsOption = nfsOption{
+var Sync Option = Option{
 	optionString: "sync",
 }
 
@@ -61,10 +77,15 @@ var Sync nfsOption = nfsOption{
 // could actually reduce performance, so no_wdelay is available to turn
 // it off.  The default can be explicitly requested with the wdelay
 // option.
-var NoWDelay nfsOption = nfsOption{
+var NoWDelay Option = Option{
 	optionString: "no_wdelay",
 }
 
+// WDelay is the opposite of NoWDelay and the default.
+var WDelay Option = Option{
+	optionString: "wdelay",
+}
+
 // NoHide is based  on  the option of the same name provided in IRIX
 // VNFS.  Normally, if a server exports two filesystems one of which
 // is mounted on the other, then the client will have to mount both
@@ -92,10 +113,15 @@ var NoWDelay nfsOption = nfsOption{
 // This option is not relevant when NFSv4 is use.  NFSv4 never hides
 // subordinate filesystems.  Any filesystem that is exported will be
 // visible where expected when using NFSv4.
-var NoHide nfsOption = nfsOption{
+var NoHide Option = Option{
 	optionString: "nohide",
 }
 
+// Hide is the opposite of NoHide and the default.
+var Hide Option = Option{
+	optionString: "hide",
+}
+
 // CrossMnt is similar to NoHide but it makes it possible for clients to
 // access all filesystems mounted on a filesystem marked with crossmnt.
 // Thus when a child filesystem "B" is mounted on a parent "A", setting
@@ -112,10 +138,15 @@ var NoHide nfsOption = nfsOption{
 //
 // The NoCrossMnt option can explictly disable CrossMnt if it was
 // previously set.  This is rarely useful.
-var CrossMnt nfsOption = nfsOption{
+var CrossMnt Option = Option{
 	optionString: "crossmnt",
 }
 
+// NoCrossMnt is the opposite of CrossMnt and the default.
+var NoCrossMnt Option = Option{
+	optionString: "nocrossmnt",
+}
+
 // NoSubtreeCheck option disables subtree checking, which has mild
 // security implications, but can improve reliability in some
 // circumstances.
@@ -151,10 +182,15 @@ var CrossMnt nfsOption = nfsOption{
 // NoSubtreeCheck a SubtreeChecking tends to cause more problems than it
 // is worth.  If you genuinely require subtree checking, you should
 // explicitly put that option in the exports file.
-var NoSubtreeCheck nfsOption = nfsOption{
+var NoSubtreeCheck Option = Option{
 	optionString: "no_subtree_check",
 }
 
+// SubtreeCheck is the opposite of NoSubtreeCheck.
+var SubtreeCheck Option = Option{
+	optionString: "subtree_check",
+}
+
 // InsecureLocks tells the NFS server not to require authentication of
 // locking requests (i.e. requests which use the NLM protocol). Normally
 // the NFS server will require a lock request to hold a credential for a
@@ -169,22 +205,22 @@ var NoSubtreeCheck nfsOption = nfsOption{
 // The default behaviour of requiring authentication for NLM requests
 // can be explicitly requested with either of the (synonymous) AuthNlm,
 // or SecureLocks.
-var InsecureLocks nfsOption = nfsOption{
+var InsecureLocks Option = Option{
 	optionString: "insecure_locks",
 }
 
 // NoAuthNLM is synonymous with InsecureLogs
-var NoAuthNLM nfsOption = nfsOption{
+var NoAuthNLM Option = Option{
 	optionString: "no_auth_nlm",
 }
 
 // SecureLocks is the opposite of InsecureLocks
-var SecureLocks nfsOption = nfsOption{
+var SecureLocks Option = Option{
 	optionString: "secure_locks",
 }
 
 // AuthNLM is the opposite of NoAuthNLM
-var AuthNLM nfsOption = nfsOption{
+var AuthNLM Option = Option{
 	optionString: "auth_nlm",
 }
 
@@ -199,8 +235,8 @@ var AuthNLM nfsOption = nfsOption{
 // If a path is given (e.g. mountpoint=/path or mp=/path) then the
 // nominated path must be a mountpoint for the exportpoint to be
 // exported.
-func MountPoint(path string) nfsOption {
-	opt := nfsOption{
+func MountPoint(path string) Option {
+	opt := Option{
 		optionString: "mountpoint",
 	}
 	if path != "" {
@@ -210,12 +246,16 @@ func MountPoint(path string) nfsOption {
 }
 
 // MP is synonymous with MountPoint
-func MP(path string) nfsOption {
+func MP(path string) Option {
 	opt := MountPoint(path)
 	opt.optionString = "mp"
 	return opt
 }
 
+// FsIDRoot marks the export as the root of all exported filesystems
+// for NFSv4. It is the same as FsID("root") and FsID("0").
+var FsIDRoot Option = FsID("root")
+
 // FsID defines an export's ID.
 //
 // NFS needs to be able to identify each filesystem that it
@@ -239,21 +279,78 @@ func MP(path string) nfsOption {
 // set for such kernels.  Setting both a small number and a UUID is
 // supported so the same configuration can be made to work on old and
 // new kernels alike.
-func FsID(id string) nfsOption {
-	return nfsOption{
+func FsID(id string) Option {
+	return Option{
 		optionString: "fsid",
 		extra:        []string{id},
 	}
 }
 
+// FsIDNumber identifies the export by a small integer. See FsID.
+func FsIDNumber(id int) Option {
+	return FsID(fmt.Sprintf("%d", id))
+}
+
 // NoRDirPlus will disable READDIRPLUS request handling.  When set,
 // READDIRPLUS requests from NFS clients return NFS3ERR_NOTSUPP, and
 // clients fall back on READDIR.  This option affects only NFSv3
 // clients.
-var NoRDirPlus nfsOption = nfsOption{
+var NoRDirPlus Option = Option{
 	optionString: "nordirplus",
 }
 
+// RDirPlus is the opposite of NoRDirPlus and the default.
+var RDirPlus Option = Option{
+	optionString: "rdirplus",
+}
+
+// NoACL makes the server not report ACLs to NFSv2 and NFSv3 clients
+// and instead tweak the permission bits it reports to approximate
+// them.
+//
+// This option is not relevant for NFSv4, and it only takes effect on
+// kernels that were built with ACL support.
+var NoACL Option = Option{
+	optionString: "no_acl",
+}
+
+// ACL is the opposite of NoACL and the default.
+var ACL Option = Option{
+	optionString: "acl",
+}
+
+// Sec lists the security flavors to be made available, in order of
+// preference, e.g. Sec("krb5p", "krb5i", "sys"). Valid flavors are
+// sys, krb5, krb5i and krb5p. The default is sys.
+//
+// Options that follow a Sec option only apply to the flavors it lists,
+// so that different flavors can have different settings.
+func Sec(flavors ...string) Option {
+	return Option{
+		optionString:     "sec",
+		extra:            flavors,
+		omitIfExtraEmpty: true,
+	}
+}
+
+// XprtSec lists the transport layer security policies clients may use,
+// in order of preference: none, tls and mtls. The default is to allow
+// all of them.
+func XprtSec(policies ...string) Option {
+	return Option{
+		optionString:     "xprtsec",
+		extra:            policies,
+		omitIfExtraEmpty: true,
+	}
+}
+
+// SecurityLabel makes the server send security labels to NFSv4.2
+// clients so that they can enforce labelled security, e.g. SELinux.
+// Without it, clients see the filesystem as unlabelled.
+var SecurityLabel Option = Option{
+	optionString: "security_label",
+}
+
 // Refer specifies relocations
 //
 // A client referencing the export point will be directed to choose from
@@ -261,8 +358,8 @@ var NoRDirPlus nfsOption = nfsOption{
 // that the server must have a mount‐point here, though a different
 // filesystem is not required; so, for example, mount --bind /path /path
 // is sufficient.)
-func Refer(references ...string) nfsOption {
-	return nfsOption{
+func Refer(references ...string) Option {
+	return Option{
 		optionString:     "refer",
 		extra:            references,
 		omitIfExtraEmpty: true,
@@ -274,8 +371,8 @@ func Refer(references ...string) nfsOption {
 // If the client asks for alternative locations for the export point, it
 // will be given this list of alternatives. (Note that actual
 // replication of the filesystem must be handled elsewhere.)
-func Replicas(replicas ...string) nfsOption {
-	return nfsOption{
+func Replicas(replicas ...string) Option {
+	return Option{
 		optionString:     "replicas",
 		extra:            replicas,
 		omitIfExtraEmpty: true,
@@ -287,40 +384,45 @@ func Replicas(replicas ...string) nfsOption {
 // pNFS clients can bypass the server and perform I/O directly to
 // storage devices. The default can be explicitly requested with the
 // NoPNFS option.
-var PNFS nfsOption = nfsOption{
+var PNFS Option = Option{
 	optionString: "pnfs",
 }
 
 // NoPNFS is the opposite of PNFS
-var NoPNFS nfsOption = nfsOption{
+var NoPNFS Option = Option{
 	optionString: "no_pnfs",
 }
 
 // RootSquash maps requests from uid/gid 0 to the anonymous uid/gid.
 // Note that this does not apply to any other uids or gids that might be
 // equally sensitive, such as user bin or group staff.
-var RootSquash nfsOption = nfsOption{
+var RootSquash Option = Option{
 	optionString: "root_squash",
 }
 
 // NoRootSquash turns off root squasing.  This option is mainly useful
 // for diskless clients.
-var NoRootSquash nfsOption = nfsOption{
+var NoRootSquash Option = Option{
 	optionString: "no_root_squash",
 }
 
 // AllSquash maps all uids and gids to the anonymous user. Useful for
 // NFS-exported public FTP directories, news spool directories, etc. The
 // opposite option is no_all_squash, which is the default setting.
-var AllSquash nfsOption = nfsOption{
+var AllSquash Option = Option{
 	optionString: "all_squash",
 }
 
+// NoAllSquash is the opposite of AllSquash and the default.
+var NoAllSquash Option = Option{
+	optionString: "no_all_squash",
+}
+
 // AnonUID explicitly set the uid of the anonymous account. This option
 // is primarily useful for PC/NFS clients, where you might want all
 // requests appear to be from one user.
-func AnonUID(uid int) nfsOption {
-	return nfsOption{
+func AnonUID(uid int) Option {
+	return Option{
 		optionString: "anonuid",
 		extra:        []string{fmt.Sprintf("%d", uid)},
 	}
@@ -329,14 +431,46 @@ func AnonUID(uid int) nfsOption {
 // AnonGID explicitly set the gid of the anonymous account. This option
 // is primarily useful for PC/NFS clients, where you might want all
 // requests appear to be from one user.
-func AnonGID(gid int) nfsOption {
-	return nfsOption{
+func AnonGID(gid int) Option {
+	return Option{
 		optionString: "anongid",
 		extra:        []string{fmt.Sprintf("%d", gid)},
 	}
 }
 
-func (opt nfsOption) string() string {
+// Name returns the option's name, e.g. "fsid" for FsID("7").
+func (opt Option) Name() string {
+	return opt.optionString
+}
+
+// Values returns the option's values, e.g. ["/a@h1", "/b@h2"] for
+// Refer("/a@h1", "/b@h2").
+func (opt Option) Values() []string {
+	return append([]string(nil), opt.extra...)
+}
+
+// String returns the option as passed to exportfs, e.g. "fsid=7".
+func (opt Option) String() string {
+	return opt.string()
+}
+
+// MarshalText returns the option as passed to exportfs, so that options
+// are encoded as strings such as "fsid=7" in JSON.
+func (opt Option) MarshalText() ([]byte, error) {
+	return []byte(opt.string()), nil
+}
+
+// UnmarshalText parses an option the way ParseOptions does.
+func (opt *Option) UnmarshalText(text []byte) error {
+	parsed, err := parseOption(string(text))
+	if err != nil {
+		return err
+	}
+	*opt = parsed
+	return nil
+}
+
+func (opt Option) string() string {
 	extrasString := opt.extrasString()
 	if extrasString == "" && opt.omitIfExtraEmpty {
 		return ""
@@ -344,7 +478,7 @@ func (opt nfsOption) string() string {
 	return fmt.Sprintf("%s%s", opt.optionString, extrasString)
 }
 
-func optionsString(options []nfsOption) string {
+func optionsString(options []Option) string {
 	var optStrings []string
 	for _, opt := range options {
 		optStrings = append(optStrings, opt.string())
@@ -352,7 +486,7 @@ func optionsString(options []nfsOption) string {
 	return strings.Join(optStrings, ",")
 }
 
-func (opt nfsOption) extrasString() string {
+func (opt Option) extrasString() string {
 	extras := make([]string, 0)
 	for _, extra := range opt.extra {
 		if extra != "" {
@@ -365,8 +499,8 @@ func (opt nfsOption) extrasString() string {
 	return ""
 }
 
-func exportFSCommandLine(path string, host string, options []nfsOption) []string {
-	var exportString string = fmt.Sprintf("%s:%s", host, path)
+func exportFSCommandLine(path string, client Client, options []Option) []string {
+	var exportString string = fmt.Sprintf("%s:%s", client.commandLineString(), path)
 
 	cmd := []string{"exportfs", exportString}
 	if len(options) > 0 {
@@ -375,60 +509,208 @@ func exportFSCommandLine(path string, host string, options []nfsOption) []string
 	return cmd
 }
 
-func unExportFSCommandLine(path string, host string) []string {
-	var exportString string = fmt.Sprintf("%s:%s", host, path)
+func unExportFSCommandLine(path string, client Client) []string {
+	var exportString string = fmt.Sprintf("%s:%s", client.commandLineString(), path)
 
 	return []string{"exportfs", "-u", exportString}
 }
 
 type execCommander func(name string, arg ...string) *exec.Cmd
-type commandRetrierWithSudo func([]string, execCommander) error
+type commandRetrierWithPrivilege func(context.Context, []string, execCommander, Privilege, Logger) ([]byte, error)
 
-type nfsManager struct {
+// Manager manages the export table by running exportfs. It implements
+// Exporter.
+type Manager struct {
+	// Command creates the commands Manager runs. It defaults to
+	// exec.Command.
 	Command        execCommander
-	commandRetrier commandRetrierWithSudo
+	commandRetrier commandRetrierWithPrivilege
+
+	// Privilege is used to retry commands that fail for lack of
+	// privileges. It defaults to Sudo{}, i.e. "sudo -n".
+	Privilege Privilege
+
+	// Logger receives a record for every command run. It defaults to
+	// discarding them.
+	Logger Logger
+
+	// Owner names the managed exports file of a persistent manager. See
+	// Persistent.
+	Owner string
+	// ExportsDir is the directory holding the managed exports file.
+	ExportsDir string
+
+	// MountCheck decides whether paths are checked with CheckMountPoint
+	// before they are exported. It defaults to MountCheckOff.
+	MountCheck MountCheck
+	// Root is prepended to the files Manager inspects, such as
+	// /proc/self/mountinfo. It defaults to "/".
+	Root string
+
+	// FsIDStatePath is the file AllocateFsID records allocations in. It
+	// defaults to DefaultFsIDStatePath under Root.
+	FsIDStatePath string
+
+	// Backend, if set, changes and lists the export table instead of
+	// exportfs, e.g. a *Kernel. Everything else Manager does, such as
+	// Apply, batches and their rollback, persistence, dry runs and
+	// mount checks, works the same on top of it.
+	Backend Exporter
+
+	plan *Plan
+}
+
+// NFSManager returns a Manager that runs exportfs directly, retrying
+// with sudo if it lacks the privileges to change the export table.
+func NFSManager() *Manager {
+	return &Manager{
+		Command:        exec.Command,
+		commandRetrier: runAndEscalateOnPermissionError,
+		Privilege:      Sudo{},
+		Logger:         NopLogger,
+	}
 }
 
-func NFSManager() *nfsManager {
-	return &nfsManager{
-		Command:        exec.Command,
-		commandRetrier: runAndRetryWithSudoOnFailure,
+// ExportFs will export path to client with the given options.
+// Note: The export is not persisted to /etc/exports unless the manager
+// is persistent, in which case it is added to the managed file instead.
+func (n *Manager) ExportFs(path string, client Client, options ...Option) error {
+	return n.ExportFsContext(context.Background(), path, client, options...)
+}
+
+// ExportFsContext is like ExportFs, but exportfs is killed if ctx is
+// done before it completes.
+//
+// The client and options are validated, and the path checked as
+// MountCheck says, before anything is run.
+func (n *Manager) ExportFsContext(ctx context.Context, path string, client Client, options ...Option) error {
+	if err := client.Validate(); err != nil {
+		return err
+	}
+	if err := ValidateOptions(options...); err != nil {
+		return err
+	}
+	if err := n.checkMount(path, options); err != nil {
+		return err
 	}
+	if n.Owner != "" {
+		return n.persistExport(ctx, path, ClientExport{Client: client, Options: options})
+	}
+	return n.exportFs(ctx, path, client, options)
 }
 
-// ExportFs will export path to host with the given options.
-// Note: The export is not persisted to /etc/exports
-func (n *nfsManager) ExportFs(path string, host string, options ...nfsOption) error {
-	return n.commandRetrier(exportFSCommandLine(path, host, options), n.Command)
+// UnExportFs will unexport path from client.
+// Note: The export is not removed from /etc/exports if it's there. A
+// persistent manager removes it from its managed file instead.
+func (n *Manager) UnExportFs(path string, client Client) error {
+	return n.UnExportFsContext(context.Background(), path, client)
 }
 
-// UnExportFs will unexport path to host with the given options.
-// Note: The export is not removed from /etc/exports if it's there
-func (n *nfsManager) UnExportFs(path string, host string) error {
-	return n.commandRetrier(unExportFSCommandLine(path, host), n.Command)
+// UnExportFsContext is like UnExportFs, but exportfs is killed if ctx
+// is done before it completes.
+func (n *Manager) UnExportFsContext(ctx context.Context, path string, client Client) error {
+	if n.Owner != "" {
+		return n.unpersistExport(ctx, path, client)
+	}
+	return n.unExportFs(ctx, path, client)
 }
 
-func runAndRetryWithSudoOnFailure(cmdLine []string, command execCommander) error {
+// runAndEscalateOnPermissionError runs cmdLine and, if it fails for
+// lack of privileges, retries it as escalated by privilege.
+func runAndEscalateOnPermissionError(ctx context.Context, cmdLine []string, command execCommander, privilege Privilege, logger Logger) ([]byte, error) {
+	start := time.Now()
 	cmd := command(cmdLine[0], cmdLine[1:]...)
-	out, err := cmd.CombinedOutput()
+	stdout, stderr, err := runCommand(ctx, cmd)
+	logAttempt(logger, cmdLine, 1, false, time.Since(start), stderr, err)
 
 	if err != nil {
-		log.Printf("Command '%v' failed: %s:\n%s", cmd, err, out)
-		log.Printf("Retrying with sudo")
-
-		cmdLine = append(cmdLine, "", "")
-		copy(cmdLine[2:], cmdLine)
-		cmdLine[0] = "sudo"
-		cmdLine[1] = "-n"
+		exportErr := newExportError(cmdLine, nil, stdout, stderr, err)
+		escalated := escalateCommandLine(privilege, cmdLine)
+		if ctx.Err() != nil || exportErr.Cause != CausePermissionDenied || escalated == nil {
+			return stdout, exportErr
+		}
 
-		cmd = command(cmdLine[0], cmdLine[1:]...)
-		out, err := cmd.CombinedOutput()
-		_ = out
+		start = time.Now()
+		cmd = command(escalated[0], escalated[1:]...)
+		stdout, stderr, err = runCommand(ctx, cmd)
+		logAttempt(logger, escalated, 2, true, time.Since(start), stderr, err)
 
 		if err != nil {
-			log.Printf("Command '%v' failed with sudo as well: %s:\n%s", cmd, err, out)
-			return fmt.Errorf("Command %v failed with sudo as well: %s, %w", cmd, out, err)
+			return stdout, newExportError(cmdLine, escalated, stdout, stderr, err)
 		}
 	}
-	return nil
+	return stdout, nil
+}
+
+func newExportError(cmdLine []string, escalated []string, stdout []byte, stderr []byte, err error) *ExportError {
+	return &ExportError{
+		CommandLine: cmdLine,
+		ExitCode:    exitCode(err),
+		Stdout:      stdout,
+		Stderr:      stderr,
+		Escalated:   escalated,
+		Cause:       classifyOutput(append(append([]byte{}, stderr...), stdout...)),
+		Err:         err,
+	}
+}
+
+// runCommand runs cmd and returns its output. If ctx is done before cmd
+// exits, cmd and the processes it started are killed and the returned
+// error wraps ctx.Err().
+func runCommand(ctx context.Context, cmd *exec.Cmd) ([]byte, []byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, fmt.Errorf("Command %v not started: %w", cmd, err)
+	}
+
+	var stdout, stderr lockedBuffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return stdout.Bytes(), stderr.Bytes(), err
+	case <-ctx.Done():
+		killProcessGroup(cmd)
+		// Wait only briefly: processes we may not signal, such as a
+		// command run by sudo, keep the output pipes open, and Wait
+		// doesn't return until they exit.
+		select {
+		case <-done:
+		case <-time.After(killGracePeriod):
+		}
+		return stdout.Bytes(), stderr.Bytes(), fmt.Errorf("Command %v killed: %w", cmd, ctx.Err())
+	}
+}
+
+// killGracePeriod is how long runCommand waits for the output of a
+// killed command.
+const killGracePeriod = 100 * time.Millisecond
+
+// lockedBuffer is a bytes.Buffer that may be read while a command that
+// outlived runCommand is still writing to it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Bytes returns a copy of what has been written so far.
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
 }
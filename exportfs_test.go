@@ -1,32 +1,42 @@
 package nfsmanager
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os/exec"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func Test_exportFSCommandLine(t *testing.T) {
 	type args struct {
 		path    string
-		host    string
-		options []nfsOption
+		client  Client
+		options []Option
 	}
 	tests := []struct {
 		name string
 		args args
 		want []string
 	}{
-		{"No Options", args{"/foo/bar", "192.168.1.1", []nfsOption{}}, []string{"exportfs", "192.168.1.1:/foo/bar"}},
-		{"One extra-less option", args{"/foo/bar", "192.168.1.1", []nfsOption{NoRootSquash}}, []string{"exportfs", "192.168.1.1:/foo/bar", "-o", "no_root_squash"}},
-		{"Two extra-less options", args{"/foo/bar", "192.168.1.1", []nfsOption{NoRootSquash, InsecureLocks}}, []string{"exportfs", "192.168.1.1:/foo/bar", "-o", "no_root_squash,insecure_locks"}},
-		{"Two option: one extra-less, one with extras", args{"/foo/bar", "192.168.1.1", []nfsOption{NoRootSquash, FsID("some-id")}}, []string{"exportfs", "192.168.1.1:/foo/bar", "-o", "no_root_squash,fsid=some-id"}},
-		{"Option with multiple extras", args{"/foo/bar", "192.168.1.1", []nfsOption{Replicas("foo", "bar")}}, []string{"exportfs", "192.168.1.1:/foo/bar", "-o", "replicas=foo:bar"}},
+		{"No Options", args{"/foo/bar", Host("192.168.1.1"), []Option{}}, []string{"exportfs", "192.168.1.1:/foo/bar"}},
+		{"One extra-less option", args{"/foo/bar", Host("192.168.1.1"), []Option{NoRootSquash}}, []string{"exportfs", "192.168.1.1:/foo/bar", "-o", "no_root_squash"}},
+		{"Two extra-less options", args{"/foo/bar", Host("192.168.1.1"), []Option{NoRootSquash, InsecureLocks}}, []string{"exportfs", "192.168.1.1:/foo/bar", "-o", "no_root_squash,insecure_locks"}},
+		{"Two option: one extra-less, one with extras", args{"/foo/bar", Host("192.168.1.1"), []Option{NoRootSquash, FsID("some-id")}}, []string{"exportfs", "192.168.1.1:/foo/bar", "-o", "no_root_squash,fsid=some-id"}},
+		{"Option with multiple extras", args{"/foo/bar", Host("192.168.1.1"), []Option{Replicas("foo", "bar")}}, []string{"exportfs", "192.168.1.1:/foo/bar", "-o", "replicas=foo:bar"}},
+		{"Host name", args{"/foo/bar", Host("nfs.example.com"), nil}, []string{"exportfs", "nfs.example.com:/foo/bar"}},
+		{"IPv6 host", args{"/foo/bar", Host("fe80::1"), nil}, []string{"exportfs", "[fe80::1]:/foo/bar"}},
+		{"IPv4 network", args{"/foo/bar", Network("10.0.0.0/8"), nil}, []string{"exportfs", "10.0.0.0/8:/foo/bar"}},
+		{"IPv6 network", args{"/foo/bar", Network("fd00::/64"), nil}, []string{"exportfs", "[fd00::/64]:/foo/bar"}},
+		{"Netgroup", args{"/foo/bar", Netgroup("trusted"), nil}, []string{"exportfs", "@trusted:/foo/bar"}},
+		{"Wildcard", args{"/foo/bar", Wildcard("*.example.com"), nil}, []string{"exportfs", "*.example.com:/foo/bar"}},
+		{"Anonymous", args{"/foo/bar", Anonymous, nil}, []string{"exportfs", "*:/foo/bar"}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := exportFSCommandLine(tt.args.path, tt.args.host, tt.args.options); !reflect.DeepEqual(got, tt.want) {
+			if got := exportFSCommandLine(tt.args.path, tt.args.client, tt.args.options); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("exportFSCommandLine() = %v, want %v", got, tt.want)
 			}
 		})
@@ -35,19 +45,20 @@ func Test_exportFSCommandLine(t *testing.T) {
 
 func Test_unExportFSCommandLine(t *testing.T) {
 	type args struct {
-		path string
-		host string
+		path   string
+		client Client
 	}
 	tests := []struct {
 		name string
 		args args
 		want []string
 	}{
-		{"No Options", args{"/foo/bar", "192.168.1.1"}, []string{"exportfs", "-u", "192.168.1.1:/foo/bar"}},
+		{"No Options", args{"/foo/bar", Host("192.168.1.1")}, []string{"exportfs", "-u", "192.168.1.1:/foo/bar"}},
+		{"IPv6 host", args{"/foo/bar", Host("fe80::1")}, []string{"exportfs", "-u", "[fe80::1]:/foo/bar"}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := unExportFSCommandLine(tt.args.path, tt.args.host); !reflect.DeepEqual(got, tt.want) {
+			if got := unExportFSCommandLine(tt.args.path, tt.args.client); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("exportFSCommandLine() = %v, want %v", got, tt.want)
 			}
 		})
@@ -57,17 +68,23 @@ func Test_unExportFSCommandLine(t *testing.T) {
 func Test_nfsOptions(t *testing.T) {
 	tests := []struct {
 		name   string
-		option nfsOption
+		option Option
 		want   string
 	}{
 		{"Secure", Secure, "secure"},
+		{"InSecure", InSecure, "insecure"},
 		{"RW", RW, "rw"},
+		{"RO", RO, "ro"},
 		{"ASync", ASync, "async"},
 		{"Sync", Sync, "sync"},
 		{"NoWDelay", NoWDelay, "no_wdelay"},
+		{"WDelay", WDelay, "wdelay"},
 		{"NoHide", NoHide, "nohide"},
+		{"Hide", Hide, "hide"},
 		{"CrossMnt", CrossMnt, "crossmnt"},
+		{"NoCrossMnt", NoCrossMnt, "nocrossmnt"},
 		{"NoSubtreeCheck", NoSubtreeCheck, "no_subtree_check"},
+		{"SubtreeCheck", SubtreeCheck, "subtree_check"},
 		{"InsecureLocks", InsecureLocks, "insecure_locks"},
 		{"NoAuthNLM", NoAuthNLM, "no_auth_nlm"},
 		{"SecureLocks", SecureLocks, "secure_locks"},
@@ -77,7 +94,19 @@ func Test_nfsOptions(t *testing.T) {
 		{"MP with empty string arg", MP(""), "mp"},
 		{"MP with arg", MP("/foo/bar"), "mp=/foo/bar"},
 		{"FsID", FsID("the-fs-id"), "fsid=the-fs-id"},
+		{"FsID with UUID", FsID("6ba7b810-9dad-11d1-80b4-00c04fd430c8"), "fsid=6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+		{"FsIDRoot", FsIDRoot, "fsid=root"},
+		{"FsIDNumber", FsIDNumber(7), "fsid=7"},
 		{"NoRDirPlus", NoRDirPlus, "nordirplus"},
+		{"RDirPlus", RDirPlus, "rdirplus"},
+		{"NoACL", NoACL, "no_acl"},
+		{"ACL", ACL, "acl"},
+		{"Sec with no args", Sec(), ""},
+		{"Sec with one flavor", Sec("krb5p"), "sec=krb5p"},
+		{"Sec with flavors", Sec("krb5p", "krb5i", "sys"), "sec=krb5p:krb5i:sys"},
+		{"XprtSec with no args", XprtSec(), ""},
+		{"XprtSec with policies", XprtSec("mtls", "tls"), "xprtsec=mtls:tls"},
+		{"SecurityLabel", SecurityLabel, "security_label"},
 		{"Refer with no args", Refer(), ""},
 		{"Refer with args", Refer("foo", "bar"), "refer=foo:bar"},
 		{"Refer with args, first is empty string", Refer("", "bar"), "refer=bar"},
@@ -93,6 +122,7 @@ func Test_nfsOptions(t *testing.T) {
 		{"RootSquash", RootSquash, "root_squash"},
 		{"NoRootSquash", NoRootSquash, "no_root_squash"},
 		{"AllSquash", AllSquash, "all_squash"},
+		{"NoAllSquash", NoAllSquash, "no_all_squash"},
 		{"AnonUID", AnonUID(1234), "anonuid=1234"},
 		{"AnonGID", AnonGID(2345), "anongid=2345"},
 	}
@@ -105,12 +135,18 @@ func Test_nfsOptions(t *testing.T) {
 	}
 }
 
-func Test_runAndRetryWithSudoOnFailure(t *testing.T) {
+func Test_runAndEscalateOnPermissionError(t *testing.T) {
 	succeed := func(name string, arg ...string) *exec.Cmd {
 		return exec.Command("true")
 	}
 	fail := func(name string, arg ...string) *exec.Cmd {
-		return exec.Command("false")
+		return exec.Command("sh", "-c", "echo 'Permission denied' >&2; exit 1")
+	}
+	failBadOption := func(name string, arg ...string) *exec.Cmd {
+		if name != "exportfs" {
+			return succeed(name, arg...)
+		}
+		return exec.Command("sh", "-c", "echo 'unknown keyword' >&2; exit 1")
 	}
 	succeedOnlyWithSudo := func(name string, arg ...string) *exec.Cmd {
 		if name == "sudo" {
@@ -118,27 +154,35 @@ func Test_runAndRetryWithSudoOnFailure(t *testing.T) {
 		}
 		return fail(name, arg...)
 	}
-	type fields struct {
-		Command execCommander
+	succeedOnlyWithDoas := func(name string, arg ...string) *exec.Cmd {
+		if name == "doas" {
+			return succeed(name, arg...)
+		}
+		return fail(name, arg...)
 	}
-	type args struct {
-		path    string
-		host    string
-		options []nfsOption
+	type fields struct {
+		Command   execCommander
+		Privilege Privilege
 	}
 	tests := []struct {
 		name    string
 		fields  fields
 		wantErr bool
 	}{
-		{"Works without sudo", fields{succeed}, false},
-		{"Works with sudo", fields{succeedOnlyWithSudo}, false},
-		{"Fails either way", fields{fail}, true},
+		{"Works without sudo", fields{succeed, Sudo{}}, false},
+		{"Works with sudo", fields{succeedOnlyWithSudo, Sudo{}}, false},
+		{"Works with doas", fields{succeedOnlyWithDoas, Doas{}}, false},
+		{"Doesn't use sudo when told to use doas", fields{succeedOnlyWithSudo, Doas{}}, true},
+		{"Fails either way", fields{fail, Sudo{}}, true},
+		{"No escalation", fields{succeedOnlyWithSudo, NoEscalation}, true},
+		{"Already root", fields{succeedOnlyWithSudo, AlreadyRoot}, true},
+		{"Nil privilege", fields{succeedOnlyWithSudo, nil}, true},
+		{"No escalation for other errors", fields{failBadOption, Sudo{}}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := runAndRetryWithSudoOnFailure([]string{"true"}, tt.fields.Command); (err != nil) != tt.wantErr {
-				t.Errorf("nfsManager.ExportFs() error = %v, wantErr %v", err, tt.wantErr)
+			if _, err := runAndEscalateOnPermissionError(context.Background(), []string{"exportfs"}, tt.fields.Command, tt.fields.Privilege, NopLogger); (err != nil) != tt.wantErr {
+				t.Errorf("Manager.ExportFs() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
@@ -147,10 +191,10 @@ func Test_runAndRetryWithSudoOnFailure(t *testing.T) {
 func TestNFSManager(t *testing.T) {
 	tests := []struct {
 		name string
-		want *nfsManager
+		want *Manager
 	}{
 		// TODO: Add test cases.
-		{"NFSManager", &nfsManager{Command: exec.Command}},
+		{"NFSManager", &Manager{Command: exec.Command}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -166,37 +210,37 @@ func TestNFSManager(t *testing.T) {
 func Test_nfsManager_ExportFs(t *testing.T) {
 	type args struct {
 		path    string
-		host    string
-		options []nfsOption
+		client  Client
+		options []Option
 	}
 	tests := []struct {
 		name    string
 		args    args
 		wantErr bool
 	}{
-		{"Success", args{"/foo/bar", "the.client", []nfsOption{}}, false},
-		{"Failure", args{"/foo/bar", "the.client", []nfsOption{}}, true},
+		{"Success", args{"/foo/bar", Host("the.client"), []Option{}}, false},
+		{"Failure", args{"/foo/bar", Host("the.client"), []Option{}}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			n := NFSManager()
 
-			commandRetrier := func(cmdLine []string, command execCommander) error {
-				want := exportFSCommandLine(tt.args.path, tt.args.host, tt.args.options)
+			commandRetrier := func(ctx context.Context, cmdLine []string, command execCommander, privilege Privilege, logger Logger) ([]byte, error) {
+				want := exportFSCommandLine(tt.args.path, tt.args.client, tt.args.options)
 				if !reflect.DeepEqual(want, cmdLine) {
 					t.Errorf("Got cmdLine = %v, wanted %v", cmdLine, want)
 				}
 
 				if tt.wantErr {
-					return fmt.Errorf("Mock failure")
+					return nil, fmt.Errorf("Mock failure")
 				}
 
-				return nil
+				return nil, nil
 			}
 			n.commandRetrier = commandRetrier
 
-			if err := n.ExportFs(tt.args.path, tt.args.host, tt.args.options...); (err != nil) != tt.wantErr {
-				t.Errorf("nfsManager.ExportFs() error = %v, wantErr %v", err, tt.wantErr)
+			if err := n.ExportFs(tt.args.path, tt.args.client, tt.args.options...); (err != nil) != tt.wantErr {
+				t.Errorf("Manager.ExportFs() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
@@ -204,37 +248,117 @@ func Test_nfsManager_ExportFs(t *testing.T) {
 
 func Test_nfsManager_UnExportFs(t *testing.T) {
 	type args struct {
-		path string
-		host string
+		path   string
+		client Client
 	}
 	tests := []struct {
 		name    string
 		args    args
 		wantErr bool
 	}{
-		{"Success", args{"/foo/bar", "the.client"}, false},
-		{"Failure", args{"/foo/bar", "the.client"}, true},
+		{"Success", args{"/foo/bar", Host("the.client")}, false},
+		{"Failure", args{"/foo/bar", Host("the.client")}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			n := NFSManager()
 
-			commandRetrier := func(cmdLine []string, command execCommander) error {
-				want := unExportFSCommandLine(tt.args.path, tt.args.host)
+			commandRetrier := func(ctx context.Context, cmdLine []string, command execCommander, privilege Privilege, logger Logger) ([]byte, error) {
+				want := unExportFSCommandLine(tt.args.path, tt.args.client)
 				if !reflect.DeepEqual(want, cmdLine) {
 					t.Errorf("Got cmdLine = %v, wanted %v", cmdLine, want)
 				}
 
 				if tt.wantErr {
-					return fmt.Errorf("Mock failure")
+					return nil, fmt.Errorf("Mock failure")
 				}
 
-				return nil
+				return nil, nil
 			}
 			n.commandRetrier = commandRetrier
 
-			if err := n.UnExportFs(tt.args.path, tt.args.host); (err != nil) != tt.wantErr {
-				t.Errorf("nfsManager.ExportFs() error = %v, wantErr %v", err, tt.wantErr)
+			if err := n.UnExportFs(tt.args.path, tt.args.client); (err != nil) != tt.wantErr {
+				t.Errorf("Manager.ExportFs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_runAndEscalateOnPermissionError_deadline(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmdLine []string
+	}{
+		{"Single process", []string{"sleep", "10"}},
+		// The shell's child holds on to the output pipes after the
+		// shell is killed, as exportfs does when sudo is killed.
+		{"Child process", []string{"sh", "-c", "sleep 10; echo done"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commands := 0
+			hang := func(name string, arg ...string) *exec.Cmd {
+				commands++
+				return exec.Command(tt.cmdLine[0], tt.cmdLine[1:]...)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			_, err := runAndEscalateOnPermissionError(ctx, []string{"exportfs", "-v"}, hang, Sudo{}, NopLogger)
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("runAndEscalateOnPermissionError() error = %v, want context.DeadlineExceeded", err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("runAndEscalateOnPermissionError() took %v, command was not killed", elapsed)
+			}
+			if commands != 1 {
+				t.Errorf("ran %d commands, want 1 (no sudo retry after deadline)", commands)
+			}
+		})
+	}
+}
+
+func Test_nfsManager_ExportFsContext_cancelled(t *testing.T) {
+	n := NFSManager()
+	n.Command = func(name string, arg ...string) *exec.Cmd {
+		return exec.Command("true")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := n.ExportFsContext(ctx, "/foo/bar", Host("the.client")); !errors.Is(err, context.Canceled) {
+		t.Errorf("Manager.ExportFsContext() error = %v, want context.Canceled", err)
+	}
+	if err := n.UnExportFsContext(ctx, "/foo/bar", Host("the.client")); !errors.Is(err, context.Canceled) {
+		t.Errorf("Manager.UnExportFsContext() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestOption_accessors(t *testing.T) {
+	tests := []struct {
+		name       string
+		option     Option
+		wantName   string
+		wantValues []string
+		wantString string
+	}{
+		{"Flag", NoRootSquash, "no_root_squash", nil, "no_root_squash"},
+		{"Single value", FsIDNumber(7), "fsid", []string{"7"}, "fsid=7"},
+		{"Multiple values", Refer("/a@h1", "/b@h2"), "refer", []string{"/a@h1", "/b@h2"}, "refer=/a@h1:/b@h2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.option.Name(); got != tt.wantName {
+				t.Errorf("Option.Name() = %v, want %v", got, tt.wantName)
+			}
+			if got := tt.option.Values(); !reflect.DeepEqual(got, tt.wantValues) {
+				t.Errorf("Option.Values() = %v, want %v", got, tt.wantValues)
+			}
+			if got := tt.option.String(); got != tt.wantString {
+				t.Errorf("Option.String() = %v, want %v", got, tt.wantString)
 			}
 		})
 	}
@@ -0,0 +1,286 @@
+package nfsmanager
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// ExportsFile is an exports(5) document such as /etc/exports.
+//
+// Lines that are not modified through the ExportsFile API are written
+// back exactly as they were read, so that reading and writing a file
+// without changes leaves it byte-for-byte identical.
+type ExportsFile struct {
+	Lines []*ExportsLine
+
+	missingNewline bool
+}
+
+// ExportsLine is a single logical line of an exports file. A logical
+// line may span several physical lines joined by a trailing backslash.
+type ExportsLine struct {
+	// Export is nil for blank lines and lines holding only a comment.
+	Export *Export
+
+	// DefaultOptions are the "-" prefixed options that apply to every
+	// client on the line. Client specific options take precedence.
+	DefaultOptions []Option
+
+	// Comment is a trailing comment, including the leading '#'.
+	Comment string
+
+	raw      string
+	rendered string
+}
+
+// ReadExportsFile reads and parses the exports file at path.
+func ReadExportsFile(path string) (*ExportsFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseExportsFile(bytes.NewReader(data))
+}
+
+// ParseExportsFile parses an exports(5) document.
+func ParseExportsFile(r io.Reader) (*ExportsFile, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &ExportsFile{}
+	text := string(data)
+	if text == "" {
+		return f, nil
+	}
+	f.missingNewline = !strings.HasSuffix(text, "\n")
+	text = strings.TrimSuffix(text, "\n")
+
+	physical := strings.Split(text, "\n")
+	for i := 0; i < len(physical); i++ {
+		lineNo := i + 1
+		raw := physical[i]
+		for strings.HasSuffix(physical[i], `\`) && i+1 < len(physical) {
+			i++
+			raw += "\n" + physical[i]
+		}
+
+		line, err := parseExportsLine(raw)
+		if err != nil {
+			return nil, fmt.Errorf("exports line %d: %w", lineNo, err)
+		}
+		f.Lines = append(f.Lines, line)
+	}
+	return f, nil
+}
+
+func parseExportsLine(raw string) (*ExportsLine, error) {
+	line := &ExportsLine{raw: raw}
+
+	text := strings.Replace(raw, "\\\n", " ", -1)
+	tokens, comment, err := tokenizeExportsLine(text)
+	if err != nil {
+		return nil, err
+	}
+	line.Comment = comment
+	if len(tokens) == 0 {
+		line.rendered = line.render()
+		return line, nil
+	}
+
+	path, err := unescapeOctal(tokens[0])
+	if err != nil {
+		return nil, err
+	}
+	line.Export = &Export{Path: path}
+
+	for _, token := range tokens[1:] {
+		if strings.HasPrefix(token, "-") {
+			if len(line.Export.Clients) > 0 {
+				return nil, fmt.Errorf("default options %q must precede all clients", token)
+			}
+			line.DefaultOptions = append(line.DefaultOptions, parseRawOptions(token[1:])...)
+			continue
+		}
+		if strings.HasPrefix(token, "(") {
+			// An option list without a host exports to the world.
+			token = "*" + token
+		}
+		client, err := parseClientExport(token)
+		if err != nil {
+			return nil, err
+		}
+		line.Export.Clients = append(line.Export.Clients, client)
+	}
+
+	line.rendered = line.render()
+	return line, nil
+}
+
+// tokenizeExportsLine splits a logical line on whitespace, honouring
+// double quotes, and strips a trailing comment.
+func tokenizeExportsLine(text string) ([]string, string, error) {
+	var tokens []string
+	var token strings.Builder
+	inToken, inQuotes := false, false
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+			inToken = true
+		case inQuotes:
+			token.WriteByte(c)
+		case c == '#':
+			if inToken {
+				tokens = append(tokens, token.String())
+			}
+			return tokens, text[i:], nil
+		case c == ' ' || c == '\t':
+			if inToken {
+				tokens = append(tokens, token.String())
+				token.Reset()
+				inToken = false
+			}
+		default:
+			token.WriteByte(c)
+			inToken = true
+		}
+	}
+	if inQuotes {
+		return nil, "", fmt.Errorf("unterminated quote")
+	}
+	if inToken {
+		tokens = append(tokens, token.String())
+	}
+	return tokens, "", nil
+}
+
+func (l *ExportsLine) render() string {
+	var parts []string
+	if l.Export != nil {
+		parts = append(parts, escapeExportsPath(l.Export.Path))
+		if len(l.DefaultOptions) > 0 {
+			parts = append(parts, "-"+optionsString(l.DefaultOptions))
+		}
+		for _, client := range l.Export.Clients {
+			parts = append(parts, client.string())
+		}
+	}
+	if l.Comment != "" {
+		parts = append(parts, l.Comment)
+	}
+	return strings.Join(parts, " ")
+}
+
+// String returns the line as it will be written to the exports file.
+func (l *ExportsLine) String() string {
+	rendered := l.render()
+	if l.raw != "" && rendered == l.rendered {
+		return l.raw
+	}
+	return rendered
+}
+
+func (c ClientExport) string() string {
+	if len(c.Options) == 0 {
+		return c.Client.String()
+	}
+	return fmt.Sprintf("%s(%s)", c.Client, optionsString(c.Options))
+}
+
+// escapeExportsPath escapes characters that would otherwise end or
+// change the meaning of the path field using \ooo octal escapes.
+func escapeExportsPath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c <= ' ' || c == '"' || c == '#' || c == '\\' || c >= 0x7f {
+			fmt.Fprintf(&b, "\\%03o", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// Exports returns the exports described by the file with each line's
+// default options merged into the options of its clients.
+func (f *ExportsFile) Exports() []Export {
+	var exports []Export
+	for _, line := range f.Lines {
+		if line.Export == nil {
+			continue
+		}
+		export := Export{Path: line.Export.Path}
+		for _, client := range line.Export.Clients {
+			options := append(append([]Option{}, line.DefaultOptions...), client.Options...)
+			export.Clients = append(export.Clients, ClientExport{Client: client.Client, Options: options})
+		}
+		exports = append(exports, export)
+	}
+	return exports
+}
+
+// Set adds export to the file, replacing any existing lines for the
+// same path. The replacement takes the place of the first such line.
+func (f *ExportsFile) Set(export Export) {
+	lines := f.Lines[:0]
+	replaced := false
+	for _, line := range f.Lines {
+		if line.Export == nil || line.Export.Path != export.Path {
+			lines = append(lines, line)
+			continue
+		}
+		if !replaced {
+			e := export
+			line.Export = &e
+			line.DefaultOptions = nil
+			lines = append(lines, line)
+			replaced = true
+		}
+	}
+	f.Lines = lines
+	if !replaced {
+		e := export
+		f.Lines = append(f.Lines, &ExportsLine{Export: &e})
+	}
+}
+
+// Remove removes all lines exporting path. It reports whether any line
+// was removed.
+func (f *ExportsFile) Remove(path string) bool {
+	lines := f.Lines[:0]
+	for _, line := range f.Lines {
+		if line.Export != nil && line.Export.Path == path {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	removed := len(lines) != len(f.Lines)
+	f.Lines = lines
+	return removed
+}
+
+// Bytes returns the document in exports(5) format.
+func (f *ExportsFile) Bytes() []byte {
+	var b bytes.Buffer
+	for i, line := range f.Lines {
+		b.WriteString(line.String())
+		if i < len(f.Lines)-1 || !f.missingNewline {
+			b.WriteByte('\n')
+		}
+	}
+	return b.Bytes()
+}
+
+// WriteTo writes the document in exports(5) format to w.
+func (f *ExportsFile) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(f.Bytes())
+	return int64(n), err
+}
@@ -0,0 +1,151 @@
+package nfsmanager
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testExportsFile = `# /etc/exports: the access control list for filesystems which may be exported
+#		to NFS clients.  See exports(5).
+
+/srv/nfs     192.168.1.0/24(rw,sync,no_subtree_check)   # lan
+/srv/public  -ro,async  *(all_squash)  admin(rw,no_root_squash)
+"/srv/with space"  10.0.0.1(rw)
+/srv/escaped\040path 10.0.0.2(ro)
+/srv/continued \
+	10.0.0.3(rw) \
+	10.0.0.4(ro)
+/srv/world (ro)
+`
+
+func TestParseExportsFile(t *testing.T) {
+	f, err := ParseExportsFile(strings.NewReader(testExportsFile))
+	if err != nil {
+		t.Fatalf("ParseExportsFile() error = %v", err)
+	}
+
+	want := []Export{
+		{"/srv/nfs", []ClientExport{{Network("192.168.1.0/24"), []Option{RW, Sync, NoSubtreeCheck}}}},
+		{"/srv/public", []ClientExport{
+			{Anonymous, []Option{RO, ASync, AllSquash}},
+			{Host("admin"), []Option{RO, ASync, RW, NoRootSquash}},
+		}},
+		{"/srv/with space", []ClientExport{{Host("10.0.0.1"), []Option{RW}}}},
+		{"/srv/escaped path", []ClientExport{{Host("10.0.0.2"), []Option{RO}}}},
+		{"/srv/continued", []ClientExport{{Host("10.0.0.3"), []Option{RW}}, {Host("10.0.0.4"), []Option{RO}}}},
+		{"/srv/world", []ClientExport{{Anonymous, []Option{RO}}}},
+	}
+	if got := f.Exports(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ExportsFile.Exports() = %v, want %v", got, want)
+	}
+	if got := f.Lines[3].Comment; got != "# lan" {
+		t.Errorf("Comment = %q, want %q", got, "# lan")
+	}
+}
+
+func TestParseExportsFile_errors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"Unterminated quote", "\"/srv/nfs 10.0.0.1(rw)\n"},
+		{"Default options after client", "/srv/nfs 10.0.0.1(rw) -ro\n"},
+		{"Unterminated option list", "/srv/nfs 10.0.0.1(rw\n"},
+		{"Bad escape", "/srv/nfs\\09 10.0.0.1(rw)\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseExportsFile(strings.NewReader(tt.data)); err == nil {
+				t.Errorf("ParseExportsFile() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestExportsFile_roundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"Empty", ""},
+		{"Full file", testExportsFile},
+		{"No trailing newline", "/srv/nfs   10.0.0.1(rw)"},
+		{"Whitespace only lines", "  \n\t\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseExportsFile(strings.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("ParseExportsFile() error = %v", err)
+			}
+			if got := string(f.Bytes()); got != tt.data {
+				t.Errorf("ExportsFile.Bytes() = %q, want %q", got, tt.data)
+			}
+		})
+	}
+}
+
+func TestExportsFile_Set(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		export Export
+		want   string
+	}{
+		{"Add to empty file", "",
+			Export{"/srv/nfs", []ClientExport{{Host("10.0.0.1"), []Option{RW}}}},
+			"/srv/nfs 10.0.0.1(rw)\n"},
+		{"Append", "# comment\n/srv/a   10.0.0.1(rw)\n",
+			Export{"/srv/b", []ClientExport{{Host("10.0.0.2"), nil}}},
+			"# comment\n/srv/a   10.0.0.1(rw)\n/srv/b 10.0.0.2\n"},
+		{"Replace keeps position and comment", "/srv/a   10.0.0.1(rw)  # keep\n/srv/b   10.0.0.2(rw)\n",
+			Export{"/srv/a", []ClientExport{{Host("10.0.0.3"), []Option{NoRootSquash}}}},
+			"/srv/a 10.0.0.3(no_root_squash) # keep\n/srv/b   10.0.0.2(rw)\n"},
+		{"Replace drops duplicate lines and default options", "/srv/a -ro 10.0.0.1\n/srv/b 10.0.0.2(rw)\n/srv/a 10.0.0.4\n",
+			Export{"/srv/a", []ClientExport{{Host("10.0.0.3"), []Option{RW}}}},
+			"/srv/a 10.0.0.3(rw)\n/srv/b 10.0.0.2(rw)\n"},
+		{"Path with space is escaped", "",
+			Export{"/srv/with space", []ClientExport{{Anonymous, []Option{RW}}}},
+			"/srv/with\\040space *(rw)\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseExportsFile(strings.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("ParseExportsFile() error = %v", err)
+			}
+			f.Set(tt.export)
+			if got := string(f.Bytes()); got != tt.want {
+				t.Errorf("ExportsFile.Bytes() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportsFile_Remove(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		path        string
+		want        string
+		wantRemoved bool
+	}{
+		{"Remove", "# c\n/srv/a 10.0.0.1\n/srv/b 10.0.0.2\n/srv/a 10.0.0.3\n", "/srv/a", "# c\n/srv/b 10.0.0.2\n", true},
+		{"Missing", "/srv/a 10.0.0.1\n", "/srv/b", "/srv/a 10.0.0.1\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseExportsFile(strings.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("ParseExportsFile() error = %v", err)
+			}
+			if got := f.Remove(tt.path); got != tt.wantRemoved {
+				t.Errorf("ExportsFile.Remove() = %v, want %v", got, tt.wantRemoved)
+			}
+			if got := string(f.Bytes()); got != tt.want {
+				t.Errorf("ExportsFile.Bytes() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
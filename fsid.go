@@ -0,0 +1,221 @@
+package nfsmanager
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultFsIDStatePath is where a Manager records the fsids it has
+// allocated.
+const DefaultFsIDStatePath = "/var/lib/nfsmanager/fsids.json"
+
+// fsidNamespace is the namespace of the name-based UUIDs AllocateFsID
+// derives.
+var fsidNamespace = [16]byte{132, 118, 183, 13, 32, 64, 71, 224, 140, 61, 113, 253, 143, 43, 169, 154}
+
+// fsidState is the content of the fsid state file.
+type fsidState struct {
+	// FsIDs maps exported paths to the fsids allocated for them.
+	FsIDs map[string]string `json:"fsids"`
+}
+
+// AllocateFsID returns an FsID option for exporting path, which is
+// needed for filesystems without a device or UUID of their own, such as
+// overlay, tmpfs, FUSE or bind mounts in containers.
+//
+// The fsid is a UUID derived from the UUID of the filesystem path is on
+// and path's place in it if the filesystem has one, and from path
+// otherwise, so that it is the same on every run. Allocations are
+// recorded in the state file at FsIDStatePath, and a path keeps the
+// fsid it was allocated, or that it is already exported with. If the
+// fsid is taken by another path in the state file or the live export
+// table, another one is derived. fsid=0, i.e. FsIDRoot, is reserved for
+// the NFSv4 pseudo-root and never allocated.
+func (n *Manager) AllocateFsID(path string) (Option, error) {
+	return n.AllocateFsIDContext(context.Background(), path)
+}
+
+// AllocateFsIDContext is like AllocateFsID, but exportfs is killed if
+// ctx is done before it completes.
+func (n *Manager) AllocateFsIDContext(ctx context.Context, path string) (Option, error) {
+	path = filepath.Clean(path)
+	state, err := n.readFsIDState()
+	if err != nil {
+		return Option{}, err
+	}
+	if fsid, ok := state.FsIDs[path]; ok {
+		return FsID(fsid), nil
+	}
+
+	exports, err := n.ListExportsContext(ctx)
+	if err != nil {
+		return Option{}, err
+	}
+	taken := map[string]bool{"0": true}
+	for _, fsid := range state.FsIDs {
+		taken[normalizeFsID(fsid)] = true
+	}
+	for _, export := range exports {
+		for _, client := range export.Clients {
+			for _, opt := range client.Options {
+				if opt.optionString != "fsid" || len(opt.extra) == 0 {
+					continue
+				}
+				fsid := opt.extra[0]
+				if export.Path == path && normalizeFsID(fsid) != "0" {
+					// Keep the fsid path is exported with.
+					return n.recordFsID(state, path, fsid)
+				}
+				taken[normalizeFsID(fsid)] = true
+			}
+		}
+	}
+
+	name := path
+	if fsUUID, rel, ok := n.filesystemUUID(path); ok {
+		name = fsUUID + ":" + rel
+	}
+	fsid := nameUUID(name)
+	for i := 1; taken[normalizeFsID(fsid)]; i++ {
+		fsid = nameUUID(fmt.Sprintf("%s#%d", name, i))
+	}
+	return n.recordFsID(state, path, fsid)
+}
+
+func (n *Manager) fsidStatePath() string {
+	if n.FsIDStatePath != "" {
+		return n.FsIDStatePath
+	}
+	return rootedPath(n.Root, DefaultFsIDStatePath)
+}
+
+func (n *Manager) readFsIDState() (*fsidState, error) {
+	state := &fsidState{FsIDs: make(map[string]string)}
+	content, err := ioutil.ReadFile(n.fsidStatePath())
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("%s: %w", n.fsidStatePath(), err)
+	}
+	if state.FsIDs == nil {
+		state.FsIDs = make(map[string]string)
+	}
+	return state, nil
+}
+
+// recordFsID adds the allocation of fsid to path to the state file. A
+// dry-run manager adds the write to its plan instead.
+func (n *Manager) recordFsID(state *fsidState, path string, fsid string) (Option, error) {
+	state.FsIDs[path] = fsid
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return Option{}, err
+	}
+	content = append(content, '\n')
+
+	if n.plan != nil {
+		n.plan.Steps = append(n.plan.Steps, PlanStep{File: n.fsidStatePath(), Content: content})
+		return FsID(fsid), nil
+	}
+	if err := os.MkdirAll(filepath.Dir(n.fsidStatePath()), 0755); err != nil {
+		return Option{}, err
+	}
+	if err := writeFileAtomic(n.fsidStatePath(), content, 0644); err != nil {
+		return Option{}, err
+	}
+	return FsID(fsid), nil
+}
+
+// filesystemUUID returns the UUID of the filesystem path is on, as
+// listed in /dev/disk/by-uuid, and path relative to the filesystem's
+// mount point.
+func (n *Manager) filesystemUUID(path string) (string, string, bool) {
+	mounts, err := readMountInfo(n.Root)
+	if err != nil {
+		return "", "", false
+	}
+	m, ok := mountOf(mounts, path)
+	if !ok || !strings.HasPrefix(m.source, "/dev/") {
+		return "", "", false
+	}
+	device := resolveLink(n.Root, m.source)
+
+	dir := "/dev/disk/by-uuid"
+	entries, err := ioutil.ReadDir(rootedPath(n.Root, dir))
+	if err != nil {
+		return "", "", false
+	}
+	for _, entry := range entries {
+		if resolveLink(n.Root, dir+"/"+entry.Name()) != device {
+			continue
+		}
+		rel, err := filepath.Rel(m.mountPoint, path)
+		if err != nil {
+			return "", "", false
+		}
+		return entry.Name(), rel, true
+	}
+	return "", "", false
+}
+
+// resolveLink follows the symbolic links at path under root, such as
+// /dev/mapper/data -> ../dm-0, and returns the path they lead to.
+func resolveLink(root string, path string) string {
+	for i := 0; i < 16; i++ {
+		target, err := os.Readlink(rootedPath(root, path))
+		if err != nil {
+			break
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = filepath.Clean(target)
+	}
+	return path
+}
+
+// nameUUID returns the name-based (version 5) UUID of name in
+// fsidNamespace.
+func nameUUID(name string) string {
+	h := sha1.New()
+	h.Write(fsidNamespace[:])
+	h.Write([]byte(name))
+	u := h.Sum(nil)[:16]
+	u[6] = u[6]&0x0f | 0x50
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// normalizeFsID returns fsid in a form that compares equal for the same
+// fsid however it is written: UUIDs as lowercase hex digits, numbers
+// without leading zeros, and root as 0. Both AllocateFsID's collision
+// checks and Apply's option comparison use it.
+func normalizeFsID(fsid string) string {
+	if fsid == "root" {
+		return "0"
+	}
+	if isNumber(fsid) {
+		if n := strings.TrimLeft(fsid, "0"); n != "" {
+			return n
+		}
+		return "0"
+	}
+	return strings.Map(func(c rune) rune {
+		switch {
+		case c >= '0' && c <= '9', c >= 'a' && c <= 'f':
+			return c
+		case c >= 'A' && c <= 'F':
+			return c - 'A' + 'a'
+		}
+		return -1
+	}, fsid)
+}
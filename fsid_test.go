@@ -0,0 +1,160 @@
+package nfsmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testDataUUID = "0b5f6d3c-0f4e-4b9a-9d0e-5c1b8c7f2a61"
+
+func fsidTestRoot(t *testing.T) string {
+	root := mountTestRoot(t)
+	for link, target := range map[string]string{
+		"dev/mapper/data":                  "../dm-1",
+		"dev/disk/by-uuid/" + testDataUUID: "../../dm-1",
+		"dev/disk/by-uuid/9d2c5e1a":        "../../dm-0",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, link)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestManager_AllocateFsID(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		exports []Export
+		state   string
+		want    Option
+	}{
+		{"From filesystem UUID", "/srv/data/a", nil, "", FsID(nameUUID(testDataUUID + ":a"))},
+		{"Mount point", "/srv/data", nil, "", FsID(nameUUID(testDataUUID + ":."))},
+		{"Without filesystem UUID", "/srv/with space", nil, "", FsID(nameUUID("/srv/with space"))},
+		{"Recorded", "/srv/data/a", nil, `{"fsids": {"/srv/data/a": "17"}}`, FsID("17")},
+		{"Exported", "/srv/data/a", []Export{
+			{"/srv/data/a", []ClientExport{{Anonymous, []Option{FsID("42")}}}},
+		}, "", FsID("42")},
+		{"Exported as root", "/srv/data/a", []Export{
+			{"/srv/data/a", []ClientExport{{Anonymous, []Option{FsIDRoot}}}},
+		}, "", FsID(nameUUID(testDataUUID + ":a"))},
+		{"Collides with export", "/srv/data/a", []Export{
+			{"/srv/other", []ClientExport{{Anonymous, []Option{FsID(nameUUID(testDataUUID + ":a"))}}}},
+		}, "", FsID(nameUUID(testDataUUID + ":a#1"))},
+		{"Collides with state", "/srv/data/a", nil,
+			`{"fsids": {"/srv/other": "` + nameUUID(testDataUUID+":a") + `", "/srv/third": "` + nameUUID(testDataUUID+":a#1") + `"}}`,
+			FsID(nameUUID(testDataUUID + ":a#2"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := fsidTestRoot(t)
+			defer os.RemoveAll(root)
+			if tt.state != "" {
+				writeTestFile(t, root, DefaultFsIDStatePath, tt.state)
+			}
+			n := NFSManager()
+			n.commandRetrier = newFakeExportfs(tt.exports...).run
+			n.Root = root
+
+			got, err := n.AllocateFsID(tt.path)
+			if err != nil {
+				t.Fatalf("Manager.AllocateFsID() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Manager.AllocateFsID() = %v, want %v", got, tt.want)
+			}
+
+			again, err := n.AllocateFsID(tt.path)
+			if err != nil {
+				t.Fatalf("Manager.AllocateFsID() again error = %v", err)
+			}
+			if !reflect.DeepEqual(again, tt.want) {
+				t.Errorf("Manager.AllocateFsID() again = %v, want %v", again, tt.want)
+			}
+		})
+	}
+}
+
+func TestManager_AllocateFsID_Apply(t *testing.T) {
+	root := fsidTestRoot(t)
+	defer os.RemoveAll(root)
+	n := NFSManager()
+	n.Root = root
+	n.commandRetrier = newFakeExportfs().run
+	fsid, err := n.AllocateFsID("/srv/data/a")
+	if err != nil {
+		t.Fatalf("Manager.AllocateFsID() error = %v", err)
+	}
+
+	// exportfs -v reports fsid=root as fsid=0.
+	fake := newFakeExportfs(
+		Export{"/srv", []ClientExport{{Anonymous, parseRawOptions("fsid=0,rw")}}},
+		Export{"/srv/data/a", []ClientExport{{Anonymous, []Option{FsID(strings.ToUpper(fsid.Values()[0]))}}}},
+	)
+	n.commandRetrier = fake.run
+	report, err := n.Apply([]Export{
+		{"/srv", []ClientExport{{Anonymous, []Option{FsIDRoot, RW}}}},
+		{"/srv/data/a", []ClientExport{{Anonymous, []Option{fsid}}}},
+	})
+	if err != nil {
+		t.Fatalf("Manager.Apply() error = %v", err)
+	}
+	if !report.Empty() || len(fake.calls) != 0 {
+		t.Errorf("Manager.Apply() made changes %v with calls %v, want none", report.Changes, fake.calls)
+	}
+}
+
+func TestManager_AllocateFsID_DryRun(t *testing.T) {
+	root := fsidTestRoot(t)
+	defer os.RemoveAll(root)
+	n := NFSManager()
+	n.commandRetrier = newFakeExportfs().run
+	n.Root = root
+	n, plan := n.DryRun()
+
+	if _, err := n.AllocateFsID("/srv/data/a"); err != nil {
+		t.Fatalf("Manager.AllocateFsID() error = %v", err)
+	}
+	if _, err := ioutil.ReadFile(filepath.Join(root, DefaultFsIDStatePath)); !os.IsNotExist(err) {
+		t.Errorf("state file written in dry run, error = %v", err)
+	}
+	if len(plan.Steps) == 0 || plan.Steps[len(plan.Steps)-1].File != filepath.Join(root, DefaultFsIDStatePath) {
+		t.Errorf("plan = %v, want a write of the state file", plan.Steps)
+	}
+}
+
+func Test_nameUUID(t *testing.T) {
+	got := nameUUID("/srv/data")
+	if got != nameUUID("/srv/data") {
+		t.Errorf("nameUUID() is not deterministic")
+	}
+	if len(got) != 36 || got[14] != '5' || got[19] < '8' || got[19] > 'b' {
+		t.Errorf("nameUUID() = %q, want a version 5 UUID", got)
+	}
+}
+
+func Test_normalizeFsID(t *testing.T) {
+	tests := []struct {
+		fsid string
+		want string
+	}{
+		{"root", "0"},
+		{"0", "0"},
+		{"007", "7"},
+		{"0B5F6D3C-0F4E-4B9A-9D0E-5C1B8C7F2A61", "0b5f6d3c0f4e4b9a9d0e5c1b8c7f2a61"},
+		{"0b5f6d3c0f4e4b9a9d0e5c1b8c7f2a61", "0b5f6d3c0f4e4b9a9d0e5c1b8c7f2a61"},
+	}
+	for _, tt := range tests {
+		if got := normalizeFsID(tt.fsid); got != tt.want {
+			t.Errorf("normalizeFsID(%q) = %q, want %q", tt.fsid, got, tt.want)
+		}
+	}
+}
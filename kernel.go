@@ -0,0 +1,499 @@
+package nfsmanager
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export flags as defined by the kernel in
+// include/uapi/linux/nfsd/export.h.
+const (
+	nfsexpReadOnly          = 0x0001
+	nfsexpInsecurePort      = 0x0002
+	nfsexpRootSquash        = 0x0004
+	nfsexpAllSquash         = 0x0008
+	nfsexpAsync             = 0x0010
+	nfsexpGatheredWrites    = 0x0020
+	nfsexpNoReadDirPlus     = 0x0040
+	nfsexpSecurityLabel     = 0x0080
+	nfsexpNoHide            = 0x0200
+	nfsexpNoSubtreeCheck    = 0x0400
+	nfsexpNoAuthNLM         = 0x0800
+	nfsexpFsID              = 0x2000
+	nfsexpCrossMount        = 0x4000
+	nfsexpNoACL             = 0x8000
+	nfsexpPNFS              = 0x20000
+	nfsexpSecInfoFlags      = nfsexpReadOnly | nfsexpRootSquash | nfsexpAllSquash | nfsexpInsecurePort
+	nfsexpDefaultFlags      = nfsexpReadOnly | nfsexpRootSquash | nfsexpGatheredWrites | nfsexpNoSubtreeCheck
+	nfsexpDefaultAnonID     = 65534
+	kernelCacheNeverExpires = math.MaxInt32
+)
+
+// kernelFlagOptions maps flag options to the flags they set and clear.
+var kernelFlagOptions = map[string]struct{ set, clear int }{
+	"ro":               {nfsexpReadOnly, 0},
+	"rw":               {0, nfsexpReadOnly},
+	"secure":           {0, nfsexpInsecurePort},
+	"insecure":         {nfsexpInsecurePort, 0},
+	"root_squash":      {nfsexpRootSquash, 0},
+	"no_root_squash":   {0, nfsexpRootSquash},
+	"all_squash":       {nfsexpAllSquash, 0},
+	"no_all_squash":    {0, nfsexpAllSquash},
+	"sync":             {0, nfsexpAsync},
+	"async":            {nfsexpAsync, 0},
+	"wdelay":           {nfsexpGatheredWrites, 0},
+	"no_wdelay":        {0, nfsexpGatheredWrites},
+	"rdirplus":         {0, nfsexpNoReadDirPlus},
+	"nordirplus":       {nfsexpNoReadDirPlus, 0},
+	"security_label":   {nfsexpSecurityLabel, 0},
+	"hide":             {0, nfsexpNoHide},
+	"nohide":           {nfsexpNoHide, 0},
+	"subtree_check":    {0, nfsexpNoSubtreeCheck},
+	"no_subtree_check": {nfsexpNoSubtreeCheck, 0},
+	"secure_locks":     {0, nfsexpNoAuthNLM},
+	"insecure_locks":   {nfsexpNoAuthNLM, 0},
+	"crossmnt":         {nfsexpCrossMount, 0},
+	"nocrossmnt":       {0, nfsexpCrossMount},
+	"acl":              {0, nfsexpNoACL},
+	"no_acl":           {nfsexpNoACL, 0},
+	"pnfs":             {nfsexpPNFS, 0},
+	"no_pnfs":          {0, nfsexpPNFS},
+}
+
+// secFlavorNumbers are the RPC pseudoflavors of the sec= flavors.
+var secFlavorNumbers = map[string]int{"sys": 1, "krb5": 390003, "krb5i": 390004, "krb5p": 390005}
+
+// xprtSecNumbers are the kernel's numbers for the xprtsec= policies.
+var xprtSecNumbers = map[string]int{"none": 1, "tls": 2, "mtls": 4}
+
+// Kernel changes the export table by writing to the kernel's export
+// caches in /proc/net/rpc, the way rpc.mountd does, instead of running
+// exportfs. It needs neither nfs-utils nor a subprocess, but it must run
+// as root. It is meant to be set as a Manager's Backend, which adds
+// Apply, batches, persistence, dry runs and the rest on top of it:
+//
+//	n := nfsmanager.NFSManager()
+//	n.Backend = &nfsmanager.Kernel{}
+//
+// The kernel maps client addresses to clients through the auth.unix.ip
+// cache, and refuses exports to clients it has no mapping for. Kernel
+// fills the cache in for Host clients, resolving host names. Networks,
+// wildcards, netgroups and Anonymous can only be mapped by rpc.mountd
+// as clients connect, so Kernel doesn't export to them.
+//
+// The zero value uses the real /proc files and cache entries that never
+// expire. Pointing Root at a directory of fake channel, flush and
+// content files makes for a test mode.
+type Kernel struct {
+	// Root is prepended to every file Kernel reads and writes. It
+	// defaults to "/" and is meant for testing against a directory of
+	// fake proc and exports files.
+	Root string
+
+	// TTL is how long cache entries stay valid. When they expire, the
+	// kernel asks rpc.mountd for them again. If TTL is zero, the entries
+	// never expire.
+	TTL time.Duration
+
+	now        func() time.Time
+	lookupHost func(host string) ([]string, error)
+	plan       *Plan
+}
+
+var _ Exporter = (*Kernel)(nil)
+
+func (k *Kernel) path(name string) string {
+	return rootedPath(k.Root, name)
+}
+
+func (k *Kernel) timeNow() time.Time {
+	if k.now != nil {
+		return k.now()
+	}
+	return time.Now()
+}
+
+func (k *Kernel) expiry() int64 {
+	if k.TTL == 0 {
+		return kernelCacheNeverExpires
+	}
+	return k.timeNow().Add(k.TTL).Unix()
+}
+
+// dryRun returns a copy of k that adds its writes to plan instead of
+// making them.
+func (k *Kernel) dryRun(plan *Plan) Exporter {
+	d := *k
+	d.plan = plan
+	return &d
+}
+
+// writeChannel writes line to the channel of cache.
+func (k *Kernel) writeChannel(cache string, line string) error {
+	return k.write(filepath.Join("/proc/net/rpc", cache, "channel"), line+"\n")
+}
+
+// write writes data to the cache file name in a single write, as the
+// kernel requires, or adds the write to k's plan in a dry run.
+func (k *Kernel) write(name string, data string) error {
+	name = k.path(name)
+	if k.plan != nil {
+		k.plan.Steps = append(k.plan.Steps, PlanStep{File: name, Content: []byte(data)})
+		return nil
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write([]byte(data)); err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return f.Close()
+}
+
+// ExportFs exports path to client with options by adding an entry to
+// the kernel's nfsd.export cache. client must be a Host. Refer, Replicas
+// and MountPoint are not supported, as they need rpc.mountd.
+func (k *Kernel) ExportFs(path string, client Client, options ...Option) error {
+	return k.ExportFsContext(context.Background(), path, client, options...)
+}
+
+// ExportFsContext is like ExportFs, but gives up once ctx is done.
+func (k *Kernel) ExportFsContext(ctx context.Context, path string, client Client, options ...Option) error {
+	fields, err := checkKernelExport(path, client, options)
+	if err != nil {
+		return err
+	}
+	if err := k.mapClient(ctx, client); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	line := []string{qword(client.String()), qword(path), strconv.FormatInt(k.expiry(), 10)}
+	return k.writeChannel("nfsd.export", strings.Join(append(line, fields...), " "))
+}
+
+// checkKernelExport checks that the kernel backend can export path to
+// client with options and returns the fields of the nfsd.export entry
+// that follow the expiry time.
+func checkKernelExport(path string, client Client, options []Option) ([]string, error) {
+	if err := client.Validate(); err != nil {
+		return nil, err
+	}
+	if client.Kind() != ClientHost {
+		return nil, fmt.Errorf("%s:%s: only hosts are supported by the kernel backend; %s clients need rpc.mountd", client, path, client.Kind())
+	}
+	if err := ValidateOptions(options...); err != nil {
+		return nil, fmt.Errorf("%s:%s: %w", client, path, err)
+	}
+	fields, err := kernelExportFields(options)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s: %w", client, path, err)
+	}
+	return fields, nil
+}
+
+// mapClient maps the addresses of a Host client to it in the
+// auth.unix.ip cache. This also makes the kernel aware of the client, so
+// that exports to it are accepted.
+func (k *Kernel) mapClient(ctx context.Context, client Client) error {
+	if client.Kind() != ClientHost {
+		return nil
+	}
+	addrs := []string{client.String()}
+	if net.ParseIP(client.String()) == nil {
+		lookup := k.lookupHost
+		if lookup == nil {
+			lookup = func(host string) ([]string, error) {
+				return net.DefaultResolver.LookupHost(ctx, host)
+			}
+		}
+		var err error
+		if addrs, err = lookup(client.String()); err != nil {
+			return err
+		}
+	}
+	for _, addr := range addrs {
+		line := fmt.Sprintf("nfsd %s %d %s", qword(addr), k.expiry(), qword(client.String()))
+		if err := k.writeChannel("auth.unix.ip", line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kernelExportFields returns the fields following the expiry time of an
+// nfsd.export cache entry: the flags, the anonymous uid and gid, the
+// fsid and the optional secinfo, uuid and xprtsec fields.
+func kernelExportFields(options []Option) ([]string, error) {
+	flags := nfsexpDefaultFlags
+	anonUID, anonGID, fsid := nfsexpDefaultAnonID, nfsexpDefaultAnonID, 0
+	uuid := ""
+	var xprtsec []string
+
+	type secinfo struct {
+		flavor int
+		flags  int
+	}
+	var flavors []secinfo
+	section := -1
+
+	// Like nfs-utils, flag options change the export's flags and those
+	// of the flavors of the last sec= option.
+	apply := func(set, clear int) {
+		flags = flags&^clear | set
+		if section < 0 {
+			return
+		}
+		for i := section; i < len(flavors); i++ {
+			flavors[i].flags = flavors[i].flags&^clear | set
+		}
+	}
+
+	for _, opt := range options {
+		opt = opt.canonical()
+		values := nonEmpty(opt.extra)
+		if bits, ok := kernelFlagOptions[opt.optionString]; ok {
+			apply(bits.set, bits.clear)
+			continue
+		}
+		switch opt.optionString {
+		case "anonuid", "anongid":
+			if len(values) != 1 {
+				return nil, fmt.Errorf("%s needs an id", opt.optionString)
+			}
+			id, err := strconv.Atoi(values[0])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", opt.string(), err)
+			}
+			if opt.optionString == "anonuid" {
+				anonUID = id
+			} else {
+				anonGID = id
+			}
+		case "fsid":
+			flags |= nfsexpFsID
+			switch id := values[0]; {
+			case id == "root":
+				fsid = 0
+			case isNumber(id):
+				n, err := strconv.Atoi(id)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", opt.string(), err)
+				}
+				fsid = n
+			default:
+				flags &^= nfsexpFsID
+				uuid = strings.Map(func(c rune) rune {
+					if strings.ContainsRune("0123456789abcdefABCDEF", c) {
+						return c
+					}
+					return -1
+				}, id)
+			}
+		case "sec":
+			section = len(flavors)
+			for _, name := range values {
+				flavors = append(flavors, secinfo{flavor: secFlavorNumbers[name], flags: flags & nfsexpSecInfoFlags})
+			}
+		case "xprtsec":
+			xprtsec = nil
+			for _, name := range values {
+				xprtsec = append(xprtsec, strconv.Itoa(xprtSecNumbers[name]))
+			}
+		default:
+			return nil, fmt.Errorf("%s is not supported by the kernel backend", opt.optionString)
+		}
+	}
+
+	fields := []string{strconv.Itoa(flags), strconv.Itoa(anonUID), strconv.Itoa(anonGID), strconv.Itoa(fsid)}
+	if len(flavors) > 0 {
+		fields = append(fields, "secinfo", strconv.Itoa(len(flavors)))
+		for _, f := range flavors {
+			fields = append(fields, strconv.Itoa(f.flavor), strconv.Itoa(f.flags&nfsexpSecInfoFlags))
+		}
+	}
+	if uuid != "" {
+		fields = append(fields, "uuid", `\x`+strings.ToLower(uuid))
+	}
+	if len(xprtsec) > 0 {
+		fields = append(fields, "xprtsec", strconv.Itoa(len(xprtsec)))
+		fields = append(fields, xprtsec...)
+	}
+	return fields, nil
+}
+
+// UnExportFs unexports path from client by replacing its entry in the
+// nfsd.export cache with a negative one. It is an error if the kernel
+// doesn't list path as exported to client.
+func (k *Kernel) UnExportFs(path string, client Client) error {
+	return k.UnExportFsContext(context.Background(), path, client)
+}
+
+// UnExportFsContext is like UnExportFs, but gives up once ctx is done.
+func (k *Kernel) UnExportFsContext(ctx context.Context, path string, client Client) error {
+	if err := client.Validate(); err != nil {
+		return err
+	}
+	exports, err := k.ListExportsContext(ctx)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, e := range exports {
+		for _, c := range e.Clients {
+			if e.Path == path && c.Client == client {
+				found = true
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("%s:%s is not exported", client, path)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return k.writeChannel("nfsd.export", fmt.Sprintf("%s %s %d", qword(client.String()), qword(path), k.expiry()))
+}
+
+// ListExports returns the exports in the kernel's nfsd.export cache.
+// Negative entries, which the kernel keeps for unexported paths, are
+// left out.
+func (k *Kernel) ListExports() ([]Export, error) {
+	return k.ListExportsContext(context.Background())
+}
+
+// ListExportsContext is like ListExports, but gives up once ctx is done.
+func (k *Kernel) ListExportsContext(ctx context.Context) ([]Export, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(k.path("/proc/net/rpc/nfsd.export/content"))
+	if err != nil {
+		return nil, err
+	}
+	return parseKernelExports(content)
+}
+
+// parseKernelExports parses the content of the nfsd.export cache. Each
+// line is a path, a tab and a client followed by its options in
+// parentheses. Lines starting with # are comments.
+func parseKernelExports(content []byte) ([]Export, error) {
+	var lines []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "#") || strings.HasSuffix(line, "()") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	exports, err := parseExportfsVerbose([]byte(strings.Join(lines, "\n")))
+	if err != nil {
+		return nil, err
+	}
+	for _, export := range exports {
+		for _, client := range export.Clients {
+			for i, opt := range client.Options {
+				if opt.optionString == "sec" {
+					client.Options[i] = secFlavorNames(opt)
+				}
+			}
+		}
+	}
+	return exports, nil
+}
+
+// secFlavorNames replaces the pseudoflavor numbers the kernel reports in
+// sec= with their names.
+func secFlavorNames(opt Option) Option {
+	var flavors []string
+	for _, flavor := range opt.extra {
+		for name, number := range secFlavorNumbers {
+			if strconv.Itoa(number) == flavor {
+				flavor = name
+			}
+		}
+		flavors = append(flavors, flavor)
+	}
+	return Sec(flavors...)
+}
+
+// Reload makes the kernel's export table match /etc/exports and the
+// *.exports files in /etc/exports.d by flushing the export caches and
+// exporting everything the files list again. If any of the exports is
+// not supported, the caches are left alone.
+func (k *Kernel) Reload() error {
+	return k.ReloadContext(context.Background())
+}
+
+// ReloadContext is like Reload, but gives up once ctx is done.
+func (k *Kernel) ReloadContext(ctx context.Context) error {
+	files := []string{k.path("/etc/exports")}
+	dropIns, err := filepath.Glob(k.path(filepath.Join(DefaultExportsDir, "*.exports")))
+	if err != nil {
+		return err
+	}
+	files = append(files, dropIns...)
+
+	var exports []Export
+	for _, name := range files {
+		f, err := ReadExportsFile(name)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		exports = append(exports, f.Exports()...)
+	}
+	for _, export := range exports {
+		for _, client := range export.Clients {
+			if _, err := checkKernelExport(export.Path, client.Client, client.Options); err != nil {
+				return err
+			}
+		}
+	}
+
+	now := strconv.FormatInt(k.timeNow().Unix(), 10)
+	for _, cache := range []string{"auth.unix.ip", "nfsd.fh", "nfsd.export"} {
+		if err := k.write(filepath.Join("/proc/net/rpc", cache, "flush"), now+"\n"); err != nil {
+			return err
+		}
+	}
+
+	for _, export := range exports {
+		for _, client := range export.Clients {
+			if err := k.ExportFsContext(ctx, export.Path, client.Client, client.Options...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// qword quotes s as a word of a cache channel line, escaping the
+// characters that separate words as \ooo.
+func qword(s string) string {
+	if s == "" {
+		return `\x`
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\\' {
+			fmt.Fprintf(&b, "\\%03o", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,273 @@
+package nfsmanager
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// kernelTestRoot returns a directory laid out like / with empty cache
+// channel, flush and content files.
+func kernelTestRoot(t *testing.T) string {
+	root, err := ioutil.TempDir("", "nfsmanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cache := range []string{"nfsd.export", "auth.unix.ip", "nfsd.fh"} {
+		dir := filepath.Join(root, "proc/net/rpc", cache)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"channel", "flush", "content"} {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(root, "etc/exports.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func readKernelTestFile(t *testing.T, root, name string) string {
+	b, err := ioutil.ReadFile(filepath.Join(root, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func Test_kernelExportFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+		want    []string
+		wantErr bool
+	}{
+		{"Defaults", nil, []string{"1061", "65534", "65534", "0"}, false},
+		{"Read-write, no squashing", []Option{RW, NoRootSquash, ASync}, []string{"1072", "65534", "65534", "0"}, false},
+		{"Anonymous ids", []Option{AnonUID(1000), AnonGID(100)}, []string{"1061", "1000", "100", "0"}, false},
+		{"Numeric fsid", []Option{FsIDNumber(7)}, []string{"9253", "65534", "65534", "7"}, false},
+		{"UUID fsid", []Option{FsID("01234567-89ab-cdef-0123-456789ABCDEF")},
+			[]string{"1061", "65534", "65534", "0", "uuid", `\x0123456789abcdef0123456789abcdef`}, false},
+		{"Sec sections", []Option{Sec("krb5p"), RW, Sec("sys")},
+			[]string{"1060", "65534", "65534", "0", "secinfo", "2", "390005", "4", "1", "4"}, false},
+		{"Flag after sec", []Option{Sec("sys"), ASync},
+			[]string{"1077", "65534", "65534", "0", "secinfo", "1", "1", "5"}, false},
+		{"Xprtsec", []Option{XprtSec("tls", "mtls")}, []string{"1061", "65534", "65534", "0", "xprtsec", "2", "2", "4"}, false},
+		{"Refer", []Option{Refer("/a@h1")}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := kernelExportFields(tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("kernelExportFields() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("kernelExportFields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKernel_ExportFs(t *testing.T) {
+	root := kernelTestRoot(t)
+	defer os.RemoveAll(root)
+
+	k := &Kernel{Root: root, lookupHost: func(host string) ([]string, error) {
+		return []string{"10.0.0.2", "fd00::2"}, nil
+	}}
+	if err := k.ExportFs("/srv/with space", Host("10.0.0.1"), RW); err != nil {
+		t.Fatalf("Kernel.ExportFs() error = %v", err)
+	}
+	if err := k.ExportFs("/srv/a", Host("client.example.com")); err != nil {
+		t.Fatalf("Kernel.ExportFs() error = %v", err)
+	}
+	if err := k.ExportFs("/srv/a", Network("10.1.0.0/16")); err == nil {
+		t.Errorf("Kernel.ExportFs() to a network error = nil, want error")
+	}
+
+	wantExports := "10.0.0.1 /srv/with\\040space 2147483647 1060 65534 65534 0\n" +
+		"client.example.com /srv/a 2147483647 1061 65534 65534 0\n"
+	if got := readKernelTestFile(t, root, "proc/net/rpc/nfsd.export/channel"); got != wantExports {
+		t.Errorf("nfsd.export channel = %q, want %q", got, wantExports)
+	}
+	wantIPs := "nfsd 10.0.0.1 2147483647 10.0.0.1\n" +
+		"nfsd 10.0.0.2 2147483647 client.example.com\n" +
+		"nfsd fd00::2 2147483647 client.example.com\n"
+	if got := readKernelTestFile(t, root, "proc/net/rpc/auth.unix.ip/channel"); got != wantIPs {
+		t.Errorf("auth.unix.ip channel = %q, want %q", got, wantIPs)
+	}
+}
+
+func TestKernel_UnExportFs(t *testing.T) {
+	root := kernelTestRoot(t)
+	defer os.RemoveAll(root)
+
+	content := "#path domain(flags)\n" +
+		"/srv/a\t10.0.0.1(rw,no_root_squash,sync,wdelay,no_subtree_check,uuid=0123:4567:89ab:cdef,sec=1:390003)\n" +
+		"/srv/b\t10.0.0.1()\n"
+	if err := ioutil.WriteFile(filepath.Join(root, "proc/net/rpc/nfsd.export/content"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	k := &Kernel{Root: root, TTL: time.Minute, now: func() time.Time { return time.Unix(1000, 0) }}
+	exports, err := k.ListExports()
+	if err != nil {
+		t.Fatalf("Kernel.ListExports() error = %v", err)
+	}
+	if len(exports) != 1 || exports[0].Path != "/srv/a" {
+		t.Fatalf("Kernel.ListExports() = %v, want only /srv/a", exports)
+	}
+	if got := optionsString(exports[0].Clients[0].Options); got != "rw,no_root_squash,sync,wdelay,no_subtree_check,uuid=0123:4567:89ab:cdef,sec=sys:krb5" {
+		t.Errorf("Kernel.ListExports() options = %v", got)
+	}
+
+	if err := k.UnExportFs("/srv/b", Host("10.0.0.1")); err == nil {
+		t.Errorf("Kernel.UnExportFs() of an unexported path error = nil, want error")
+	}
+	if err := k.UnExportFs("/srv/a", Host("10.0.0.1")); err != nil {
+		t.Fatalf("Kernel.UnExportFs() error = %v", err)
+	}
+	if got, want := readKernelTestFile(t, root, "proc/net/rpc/nfsd.export/channel"), "10.0.0.1 /srv/a 1060\n"; got != want {
+		t.Errorf("nfsd.export channel = %q, want %q", got, want)
+	}
+}
+
+func TestKernel_Reload(t *testing.T) {
+	root := kernelTestRoot(t)
+	defer os.RemoveAll(root)
+
+	files := map[string]string{
+		"etc/exports":                 "/srv/a 10.0.0.1(rw)\n",
+		"etc/exports.d/myapp.exports": "/srv/b 10.0.0.2(ro)\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	k := &Kernel{Root: root, now: func() time.Time { return time.Unix(1000, 0) }}
+	if err := k.Reload(); err != nil {
+		t.Fatalf("Kernel.Reload() error = %v", err)
+	}
+	for _, cache := range []string{"nfsd.export", "auth.unix.ip", "nfsd.fh"} {
+		if got := readKernelTestFile(t, root, "proc/net/rpc/"+cache+"/flush"); got != "1000\n" {
+			t.Errorf("%s flush = %q, want %q", cache, got, "1000\n")
+		}
+	}
+	want := "10.0.0.1 /srv/a 2147483647 1060 65534 65534 0\n" +
+		"10.0.0.2 /srv/b 2147483647 1061 65534 65534 0\n"
+	if got := readKernelTestFile(t, root, "proc/net/rpc/nfsd.export/channel"); got != want {
+		t.Errorf("nfsd.export channel = %q, want %q", got, want)
+	}
+}
+
+func TestKernel_Reload_unsupported(t *testing.T) {
+	tests := []struct {
+		name    string
+		exports string
+	}{
+		{"Refer", "/srv/a 10.0.0.1(rw)\n/srv/b 10.0.0.1(refer=/b@h1)\n"},
+		{"Anonymous client", "/srv/a 10.0.0.1(rw)\n/srv/b *(ro)\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := kernelTestRoot(t)
+			defer os.RemoveAll(root)
+			if err := ioutil.WriteFile(filepath.Join(root, "etc/exports"), []byte(tt.exports), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			k := &Kernel{Root: root, now: func() time.Time { return time.Unix(1000, 0) }}
+			if err := k.Reload(); err == nil {
+				t.Fatalf("Kernel.Reload() error = nil, want error")
+			}
+			for _, name := range []string{"nfsd.export/flush", "auth.unix.ip/flush", "nfsd.fh/flush", "nfsd.export/channel"} {
+				if got := readKernelTestFile(t, root, "proc/net/rpc/"+name); got != "" {
+					t.Errorf("%s = %q, want it untouched", name, got)
+				}
+			}
+		})
+	}
+}
+
+func TestManager_kernelBackend(t *testing.T) {
+	root := kernelTestRoot(t)
+	defer os.RemoveAll(root)
+
+	n := NFSManager()
+	n.commandRetrier = func(ctx context.Context, cmdLine []string, command execCommander, privilege Privilege, logger Logger) ([]byte, error) {
+		t.Errorf("ran %v, want no commands with a backend", cmdLine)
+		return nil, nil
+	}
+	n.Backend = &Kernel{Root: root, now: func() time.Time { return time.Unix(1000, 0) }}
+
+	d, plan := n.DryRun()
+	report, err := d.Apply([]Export{{"/srv/a", []ClientExport{{Host("10.0.0.1"), []Option{RW}}}}})
+	if err != nil {
+		t.Fatalf("Manager.Apply() dry run error = %v", err)
+	}
+	if len(report.Changes) != 1 {
+		t.Errorf("Manager.Apply() dry run changes = %v, want one export", report.Changes)
+	}
+	wantPlan := "write " + filepath.Join(root, "proc/net/rpc/auth.unix.ip/channel") + ":\nnfsd 10.0.0.1 2147483647 10.0.0.1\n\n" +
+		"write " + filepath.Join(root, "proc/net/rpc/nfsd.export/channel") + ":\n10.0.0.1 /srv/a 2147483647 1060 65534 65534 0\n"
+	if got := plan.String(); got != wantPlan {
+		t.Errorf("plan = %q, want %q", got, wantPlan)
+	}
+	if got := readKernelTestFile(t, root, "proc/net/rpc/nfsd.export/channel"); got != "" {
+		t.Errorf("nfsd.export channel = %q after a dry run, want it untouched", got)
+	}
+
+	if _, err := n.Apply([]Export{{"/srv/a", []ClientExport{{Host("10.0.0.1"), []Option{RW}}}}}); err != nil {
+		t.Fatalf("Manager.Apply() error = %v", err)
+	}
+	if got, want := readKernelTestFile(t, root, "proc/net/rpc/nfsd.export/channel"), "10.0.0.1 /srv/a 2147483647 1060 65534 65534 0\n"; got != want {
+		t.Errorf("nfsd.export channel = %q, want %q", got, want)
+	}
+
+	n.ExportsDir = filepath.Join(root, "etc/exports.d")
+	p, err := n.Persistent("myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ExportFs("/srv/b", Host("10.0.0.2")); err != nil {
+		t.Fatalf("persistent Manager.ExportFs() error = %v", err)
+	}
+	if got := readKernelTestFile(t, root, "proc/net/rpc/nfsd.export/flush"); got != "1000\n" {
+		t.Errorf("nfsd.export flush = %q, want the persistent export reloaded through the backend", got)
+	}
+
+	content := "#path domain(flags)\n/srv/a\t10.0.0.1(rw,sync,wdelay,no_subtree_check,sec=1)\n"
+	if err := ioutil.WriteFile(filepath.Join(root, "proc/net/rpc/nfsd.export/content"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "proc/net/rpc/nfsd.export/channel"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = n.Batch().ExportFs("/srv/a", Host("10.0.0.1"), RO).ExportFs("/srv/b", Host("10.0.0.1"), Refer("/b@h1")).Commit()
+	var rollbackErr *RollbackError
+	if !errors.As(err, &rollbackErr) || len(rollbackErr.RollbackErrors) != 0 {
+		t.Fatalf("Batch.Commit() error = %v, want a successful rollback", err)
+	}
+	wantChannel := "10.0.0.1 /srv/a 2147483647 1061 65534 65534 0\n" +
+		"10.0.0.1 /srv/a 2147483647 1060 65534 65534 0 secinfo 1 1 4\n"
+	if got := readKernelTestFile(t, root, "proc/net/rpc/nfsd.export/channel"); got != wantChannel {
+		t.Errorf("nfsd.export channel = %q, want %q", got, wantChannel)
+	}
+
+	other := NFSManager()
+	other.Backend = NFSManager()
+	other, _ = other.DryRun()
+	if err := other.ExportFs("/srv/a", Host("10.0.0.1")); err == nil {
+		t.Errorf("Manager.ExportFs() dry run through a backend without dry runs error = nil, want error")
+	}
+}
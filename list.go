@@ -0,0 +1,188 @@
+package nfsmanager
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Export describes a path and the clients it is exported to.
+type Export struct {
+	Path    string         `json:"path"`
+	Clients []ClientExport `json:"clients"`
+}
+
+// ClientExport is a single client specification together with the
+// options the path is exported to it with.
+type ClientExport struct {
+	Client  Client   `json:"client"`
+	Options []Option `json:"options,omitempty"`
+}
+
+func listExportsCommandLine() []string {
+	return []string{"exportfs", "-v"}
+}
+
+// ListExports returns the currently active exports as reported by
+// `exportfs -v`, or by n's Backend.
+func (n *Manager) ListExports() ([]Export, error) {
+	return n.ListExportsContext(context.Background())
+}
+
+// ListExportsContext is like ListExports, but exportfs is killed if ctx
+// is done before it completes.
+func (n *Manager) ListExportsContext(ctx context.Context) ([]Export, error) {
+	if n.Backend != nil {
+		return n.Backend.ListExportsContext(ctx)
+	}
+	out, err := n.commandRetrier(ctx, listExportsCommandLine(), n.Command, n.Privilege, n.Logger)
+	if err != nil {
+		return nil, err
+	}
+	return parseExportfsVerbose(out)
+}
+
+// parseExportfsVerbose parses the output of `exportfs -v`. Each line
+// holds a path followed by a client and its options in parentheses.
+// exportfs wraps the line after the path when the path is long, in
+// which case the client is found, indented, on the following line.
+func parseExportfsVerbose(out []byte) ([]Export, error) {
+	var exports []Export
+	index := make(map[string]int)
+	pendingPath := ""
+
+	add := func(path string, spec string) error {
+		client, err := parseClientExport(spec)
+		if err != nil {
+			return err
+		}
+		i, ok := index[path]
+		if !ok {
+			i = len(exports)
+			index[path] = i
+			exports = append(exports, Export{Path: path})
+		}
+		exports[i].Clients = append(exports[i].Clients, client)
+		return nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			if pendingPath == "" {
+				return nil, fmt.Errorf("exportfs -v line %d: client without path: %q", lineNo, line)
+			}
+			if err := add(pendingPath, strings.TrimSpace(line)); err != nil {
+				return nil, fmt.Errorf("exportfs -v line %d: %w", lineNo, err)
+			}
+			pendingPath = ""
+			continue
+		}
+
+		if pendingPath != "" {
+			return nil, fmt.Errorf("exportfs -v line %d: path %q has no client", lineNo, pendingPath)
+		}
+
+		rawPath, spec := line, ""
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			rawPath, spec = line[:i], strings.TrimSpace(line[i:])
+		}
+		path, err := unescapeOctal(rawPath)
+		if err != nil {
+			return nil, fmt.Errorf("exportfs -v line %d: %w", lineNo, err)
+		}
+		if spec == "" {
+			pendingPath = path
+			continue
+		}
+		if err := add(path, spec); err != nil {
+			return nil, fmt.Errorf("exportfs -v line %d: %w", lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if pendingPath != "" {
+		return nil, fmt.Errorf("exportfs -v: path %q has no client", pendingPath)
+	}
+	return exports, nil
+}
+
+// parseClientExport parses a "client(opt,opt=value)" specification.
+// exportfs reports the anonymous client as "<world>".
+func parseClientExport(spec string) (ClientExport, error) {
+	name := spec
+	var options []Option
+
+	if i := strings.Index(spec, "("); i >= 0 {
+		if !strings.HasSuffix(spec, ")") {
+			return ClientExport{}, fmt.Errorf("unterminated option list in %q", spec)
+		}
+		name = spec[:i]
+		options = parseRawOptions(spec[i+1 : len(spec)-1])
+	}
+
+	client, err := ParseClient(name)
+	if err != nil {
+		return ClientExport{}, fmt.Errorf("%q: %w", spec, err)
+	}
+	return ClientExport{Client: client, Options: options}, nil
+}
+
+// parseRawOptions splits a comma separated option list into options.
+// Options ParseOptions doesn't know are kept as they are rather than
+// rejected, since exportfs reports options this package has no
+// constructor for.
+func parseRawOptions(s string) []Option {
+	var options []Option
+	for _, field := range strings.Split(s, ",") {
+		if field == "" {
+			continue
+		}
+		opt, err := parseOption(field)
+		if err != nil {
+			opt = Option{optionString: field}
+			if i := strings.Index(field, "="); i >= 0 {
+				opt.optionString = field[:i]
+				opt.extra = strings.Split(field[i+1:], ":")
+			}
+		}
+		options = append(options, opt)
+	}
+	return options
+}
+
+// unescapeOctal decodes the \ooo escapes exportfs and the exports file
+// use for characters such as spaces in paths.
+func unescapeOctal(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+4 > len(s) {
+			return "", fmt.Errorf("truncated escape in %q", s)
+		}
+		v, err := strconv.ParseUint(s[i+1:i+4], 8, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in %q", s)
+		}
+		b.WriteByte(byte(v))
+		i += 3
+	}
+	return b.String(), nil
+}
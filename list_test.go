@@ -0,0 +1,89 @@
+package nfsmanager
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func Test_parseExportfsVerbose(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    []Export
+		wantErr bool
+	}{
+		{"Empty", "", nil, false},
+		{"Single export", "/srv/nfs      \t192.168.1.0/24(rw,no_root_squash)\n",
+			[]Export{{"/srv/nfs", []ClientExport{{Network("192.168.1.0/24"), []Option{RW, NoRootSquash}}}}}, false},
+		{"Option with extras", "/srv/nfs\t10.0.0.1(fsid=7,refer=/a@h1:/b@h2)\n",
+			[]Export{{"/srv/nfs", []ClientExport{{Host("10.0.0.1"), []Option{FsID("7"), Refer("/a@h1", "/b@h2")}}}}}, false},
+		{"World client", "/srv/nfs\t<world>(ro)\n",
+			[]Export{{"/srv/nfs", []ClientExport{{Anonymous, []Option{RO}}}}}, false},
+		{"Wildcard client", "/srv/nfs\t*.example.com(rw)\n",
+			[]Export{{"/srv/nfs", []ClientExport{{Wildcard("*.example.com"), []Option{RW}}}}}, false},
+		{"Wrapped line", "/a/very/long/path/that/exportfs/decided/to/wrap\n\t\t10.0.0.1(rw)\n",
+			[]Export{{"/a/very/long/path/that/exportfs/decided/to/wrap", []ClientExport{{Host("10.0.0.1"), []Option{RW}}}}}, false},
+		{"Multiple clients are grouped by path", "/srv/nfs\t10.0.0.1(rw)\n/srv/other\t10.0.0.3(rw)\n/srv/nfs\t10.0.0.2(rw)\n",
+			[]Export{
+				{"/srv/nfs", []ClientExport{{Host("10.0.0.1"), []Option{RW}}, {Host("10.0.0.2"), []Option{RW}}}},
+				{"/srv/other", []ClientExport{{Host("10.0.0.3"), []Option{RW}}}},
+			}, false},
+		{"Escaped path", "/srv/with\\040space\t10.0.0.1(rw)\n",
+			[]Export{{"/srv/with space", []ClientExport{{Host("10.0.0.1"), []Option{RW}}}}}, false},
+		{"Path without client", "/srv/nfs\n", nil, true},
+		{"Client without path", "\t10.0.0.1(rw)\n", nil, true},
+		{"Unterminated options", "/srv/nfs\t10.0.0.1(rw\n", nil, true},
+		{"Truncated escape", "/srv/nfs\\04\t10.0.0.1(rw)\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseExportfsVerbose([]byte(tt.out))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseExportfsVerbose() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseExportfsVerbose() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_nfsManager_ListExports(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    []Export
+		wantErr bool
+	}{
+		{"Success", "/srv/nfs\t10.0.0.1(rw)\n", []Export{{"/srv/nfs", []ClientExport{{Host("10.0.0.1"), []Option{RW}}}}}, false},
+		{"Failure", "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NFSManager()
+
+			n.commandRetrier = func(ctx context.Context, cmdLine []string, command execCommander, privilege Privilege, logger Logger) ([]byte, error) {
+				want := listExportsCommandLine()
+				if !reflect.DeepEqual(want, cmdLine) {
+					t.Errorf("Got cmdLine = %v, wanted %v", cmdLine, want)
+				}
+
+				if tt.wantErr {
+					return nil, fmt.Errorf("Mock failure")
+				}
+
+				return []byte(tt.out), nil
+			}
+
+			got, err := n.ListExports()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Manager.ListExports() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Manager.ListExports() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
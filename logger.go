@@ -0,0 +1,69 @@
+package nfsmanager
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Logger receives structured log records. keyvals holds alternating keys
+// and values, e.g. "command", "exportfs -v", "attempt", 1.
+type Logger interface {
+	Log(msg string, keyvals ...interface{})
+}
+
+// LoggerFunc adapts a function to the Logger interface.
+type LoggerFunc func(msg string, keyvals ...interface{})
+
+func (f LoggerFunc) Log(msg string, keyvals ...interface{}) {
+	f(msg, keyvals...)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Log(msg string, keyvals ...interface{}) {}
+
+// NopLogger discards all records.
+var NopLogger Logger = nopLogger{}
+
+// StdLogger returns a Logger that writes records to l as a message
+// followed by key=value pairs.
+func StdLogger(l *log.Logger) Logger {
+	return LoggerFunc(func(msg string, keyvals ...interface{}) {
+		var b strings.Builder
+		b.WriteString(msg)
+		for i := 0; i < len(keyvals); i += 2 {
+			var value interface{} = "(missing)"
+			if i+1 < len(keyvals) {
+				value = keyvals[i+1]
+			}
+			fmt.Fprintf(&b, " %v=%q", keyvals[i], fmt.Sprint(value))
+		}
+		l.Print(b.String())
+	})
+}
+
+// logAttempt logs the outcome of running cmdLine.
+func logAttempt(logger Logger, cmdLine []string, attempt int, escalated bool, duration time.Duration, stderr []byte, err error) {
+	if logger == nil {
+		return
+	}
+
+	keyvals := []interface{}{
+		"command", strings.Join(cmdLine, " "),
+		"attempt", attempt,
+		"escalated", escalated,
+		"duration", duration,
+	}
+	if err == nil {
+		logger.Log("command succeeded", append(keyvals, "exit_code", 0)...)
+		return
+	}
+
+	keyvals = append(keyvals, "exit_code", exitCode(err), "error", err)
+	if len(stderr) > 0 {
+		keyvals = append(keyvals, "stderr", strings.TrimSpace(string(stderr)))
+	}
+	logger.Log("command failed", keyvals...)
+}
@@ -0,0 +1,89 @@
+package nfsmanager
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type logRecord struct {
+	msg     string
+	keyvals map[string]interface{}
+}
+
+func recordingLogger(records *[]logRecord) Logger {
+	return LoggerFunc(func(msg string, keyvals ...interface{}) {
+		r := logRecord{msg, make(map[string]interface{})}
+		for i := 0; i+1 < len(keyvals); i += 2 {
+			r.keyvals[keyvals[i].(string)] = keyvals[i+1]
+		}
+		*records = append(*records, r)
+	})
+}
+
+func Test_runAndEscalateOnPermissionError_logging(t *testing.T) {
+	succeedOnlyWithSudo := func(name string, arg ...string) *exec.Cmd {
+		if name == "sudo" {
+			return exec.Command("true")
+		}
+		return exec.Command("sh", "-c", "echo 'Permission denied' >&2; exit 2")
+	}
+
+	var records []logRecord
+	if _, err := runAndEscalateOnPermissionError(context.Background(), []string{"exportfs", "-v"}, succeedOnlyWithSudo, Sudo{}, recordingLogger(&records)); err != nil {
+		t.Fatalf("runAndEscalateOnPermissionError() error = %v", err)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("got %d log records, want 2: %v", len(records), records)
+	}
+	tests := []struct {
+		record    logRecord
+		msg       string
+		command   string
+		attempt   int
+		escalated bool
+		exitCode  int
+	}{
+		{records[0], "command failed", "exportfs -v", 1, false, 2},
+		{records[1], "command succeeded", "sudo -n exportfs -v", 2, true, 0},
+	}
+	for _, tt := range tests {
+		if tt.record.msg != tt.msg {
+			t.Errorf("msg = %q, want %q", tt.record.msg, tt.msg)
+		}
+		want := map[string]interface{}{"command": tt.command, "attempt": tt.attempt, "escalated": tt.escalated, "exit_code": tt.exitCode}
+		for k, v := range want {
+			if !reflect.DeepEqual(tt.record.keyvals[k], v) {
+				t.Errorf("%s = %v, want %v", k, tt.record.keyvals[k], v)
+			}
+		}
+		if _, ok := tt.record.keyvals["duration"].(time.Duration); !ok {
+			t.Errorf("duration missing from %v", tt.record.keyvals)
+		}
+	}
+	if got := records[0].keyvals["stderr"]; got != "Permission denied" {
+		t.Errorf("stderr = %v, want %v", got, "Permission denied")
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	StdLogger(log.New(&buf, "", 0)).Log("command failed", "command", "exportfs -v", "attempt", 1, "dangling")
+
+	want := `command failed command="exportfs -v" attempt="1" dangling="(missing)"`
+	if got := strings.TrimSpace(buf.String()); got != want {
+		t.Errorf("StdLogger() wrote %q, want %q", got, want)
+	}
+}
+
+func TestNFSManager_Logger(t *testing.T) {
+	if NFSManager().Logger != NopLogger {
+		t.Errorf("NFSManager().Logger is not NopLogger")
+	}
+}
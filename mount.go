@@ -0,0 +1,199 @@
+package nfsmanager
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MountInfoPath lists the mounts visible to the process.
+const MountInfoPath = "/proc/self/mountinfo"
+
+// MountCheck decides what a Manager does when a path fails the checks
+// of CheckMountPoint before it is exported.
+type MountCheck int
+
+const (
+	// MountCheckOff exports paths without checking them, leaving the
+	// MountPoint option to exportfs.
+	MountCheckOff MountCheck = iota
+	// MountCheckWarn logs the problem and exports the path anyway.
+	MountCheckWarn
+	// MountCheckEnforce refuses to export the path.
+	MountCheckEnforce
+)
+
+// MountError is returned by CheckMountPoint for a path that is unsafe to
+// export.
+type MountError struct {
+	Path    string
+	Problem string
+}
+
+func (e *MountError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Problem)
+}
+
+// mountInfo is a line of /proc/self/mountinfo.
+type mountInfo struct {
+	mountPoint string
+	fsType     string
+	source     string
+}
+
+// readMountInfo parses root's /proc/self/mountinfo, e.g.
+//
+//	36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw
+//
+// where the fifth field is the mount point, and the fields after the
+// "-" separator are the filesystem type and source.
+func readMountInfo(root string) ([]mountInfo, error) {
+	content, err := ioutil.ReadFile(rootedPath(root, MountInfoPath))
+	if err != nil {
+		return nil, err
+	}
+
+	var mounts []mountInfo
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 0 || sep+2 >= len(fields) {
+			return nil, fmt.Errorf("mountinfo line %d: malformed", lineNo)
+		}
+		mountPoint, err := unescapeOctal(fields[4])
+		if err != nil {
+			return nil, fmt.Errorf("mountinfo line %d: %w", lineNo, err)
+		}
+		mounts = append(mounts, mountInfo{mountPoint: mountPoint, fsType: fields[sep+1], source: fields[sep+2]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mounts, nil
+}
+
+// mountOf returns the mount path is on: the last mounted of those whose
+// mount point is the longest prefix of path.
+func mountOf(mounts []mountInfo, path string) (mountInfo, bool) {
+	var found mountInfo
+	ok := false
+	for _, m := range mounts {
+		if m.mountPoint != "/" && path != m.mountPoint && !strings.HasPrefix(path, m.mountPoint+"/") {
+			continue
+		}
+		if !ok || len(m.mountPoint) >= len(found.mountPoint) {
+			found, ok = m, true
+		}
+	}
+	return found, ok
+}
+
+// CheckMountPoint checks that path, as seen under root, is absolute,
+// exists and is a directory. If options include MountPoint or MP, it
+// also checks what exportfs would: that path, or the path given to the
+// option, is a mount point. Symbolic links are resolved first, as
+// exportfs does. A path on the root filesystem when a mount was
+// expected, such as when the filesystem meant to be mounted there
+// failed to mount, is reported as such. An empty root means "/".
+//
+// Problems are returned as a *MountError.
+func CheckMountPoint(root string, path string, options ...Option) error {
+	if !filepath.IsAbs(path) {
+		return &MountError{Path: path, Problem: "is not an absolute path"}
+	}
+	path = filepath.Clean(path)
+	resolved, err := evalSymlinks(root, path)
+	if os.IsNotExist(err) {
+		return &MountError{Path: path, Problem: "does not exist"}
+	} else if err != nil {
+		return err
+	}
+	info, err := os.Stat(rootedPath(root, resolved))
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return &MountError{Path: path, Problem: "is not a directory"}
+	}
+
+	mountPoint, expected := "", false
+	for _, opt := range options {
+		if opt.canonical().optionString != "mountpoint" {
+			continue
+		}
+		mountPoint, expected = resolved, true
+		if values := nonEmpty(opt.extra); len(values) > 0 {
+			mountPoint = filepath.Clean(values[0])
+			if p, err := evalSymlinks(root, mountPoint); err == nil {
+				mountPoint = p
+			}
+		}
+	}
+	if !expected {
+		return nil
+	}
+
+	mounts, err := readMountInfo(root)
+	if err != nil {
+		return err
+	}
+	if m, ok := mountOf(mounts, mountPoint); ok && m.mountPoint == mountPoint {
+		return nil
+	}
+	if m, ok := mountOf(mounts, resolved); ok && m.mountPoint == "/" {
+		return &MountError{Path: path, Problem: fmt.Sprintf("is on the root filesystem, but %s is not mounted", mountPoint)}
+	}
+	return &MountError{Path: path, Problem: fmt.Sprintf("%s is not a mount point", mountPoint)}
+}
+
+// evalSymlinks returns the absolute path path, as seen under root,
+// leads to once symbolic links are resolved.
+func evalSymlinks(root string, path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(rootedPath(root, path))
+	if err != nil {
+		return "", err
+	}
+	if root == "" || root == "/" {
+		return resolved, nil
+	}
+	base, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(base, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("%s leads outside of %s", path, root)
+	}
+	return filepath.Join("/", rel), nil
+}
+
+// checkMount applies n's MountCheck to exporting path with options.
+func (n *Manager) checkMount(path string, options []Option) error {
+	if n.MountCheck == MountCheckOff {
+		return nil
+	}
+	err := CheckMountPoint(n.Root, path, options...)
+	if err != nil && n.MountCheck == MountCheckWarn {
+		if n.Logger != nil {
+			n.Logger.Log("mount check failed", "path", path, "error", err)
+		}
+		return nil
+	}
+	return err
+}
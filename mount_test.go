@@ -0,0 +1,111 @@
+package nfsmanager
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testMountInfo = `22 1 253:0 / / rw,relatime shared:1 - ext4 /dev/mapper/root rw
+25 22 0:21 / /proc rw,nosuid shared:12 - proc proc rw
+40 22 253:1 / /srv/data rw,relatime shared:20 - xfs /dev/mapper/data rw
+41 22 0:40 / /srv/with\040space rw shared:21 - tmpfs tmpfs rw
+`
+
+func mountTestRoot(t *testing.T) string {
+	root, err := ioutil.TempDir("", "nfsmanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, root, "proc/self/mountinfo", testMountInfo)
+	for _, dir := range []string{"srv/data/a", "srv/with space", "srv/unmounted/a", "srv/data/unmounted"} {
+		if err := os.MkdirAll(root+"/"+dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFile(t, root, "srv/file", "")
+	if err := os.Symlink("data", root+"/srv/link"); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestCheckMountPoint(t *testing.T) {
+	root := mountTestRoot(t)
+	defer os.RemoveAll(root)
+
+	tests := []struct {
+		name    string
+		path    string
+		options []Option
+		want    string
+	}{
+		{"Plain directory", "/srv/unmounted/a", nil, ""},
+		{"Missing", "/srv/missing", nil, "does not exist"},
+		{"File", "/srv/file", nil, "is not a directory"},
+		{"Mount point", "/srv/data", []Option{MountPoint("")}, ""},
+		{"Escaped mount point", "/srv/with space", []Option{MP("")}, ""},
+		{"Below given mount point", "/srv/data/a", []Option{RW, MountPoint("/srv/data")}, ""},
+		{"Root filesystem", "/srv/unmounted/a", []Option{MountPoint("/srv/unmounted")}, "is on the root filesystem, but /srv/unmounted is not mounted"},
+		{"Not a mount point", "/srv/data/unmounted", []Option{MountPoint("")}, "/srv/data/unmounted is not a mount point"},
+		{"Root", "/", []Option{MountPoint("")}, ""},
+		{"Relative", "srv/data", []Option{MP("")}, "is not an absolute path"},
+		{"Symlink to mount point", "/srv/link", []Option{MountPoint("")}, ""},
+		{"Symlinked mount point option", "/srv/data/a", []Option{MountPoint("/srv/link")}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckMountPoint(root, tt.path, tt.options...)
+			var mountErr *MountError
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("CheckMountPoint() error = %v, want nil", err)
+			case tt.want != "" && (!errors.As(err, &mountErr) || mountErr.Problem != tt.want):
+				t.Errorf("CheckMountPoint() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestManager_MountCheck(t *testing.T) {
+	root := mountTestRoot(t)
+	defer os.RemoveAll(root)
+
+	fake := newFakeExportfs()
+	n := NFSManager()
+	n.commandRetrier = fake.run
+	n.Root = root
+
+	if err := n.ExportFs("/srv/missing", Anonymous); err != nil {
+		t.Errorf("Manager.ExportFs() without mount check error = %v", err)
+	}
+
+	var logged []string
+	n.Logger = LoggerFunc(func(msg string, keyvals ...interface{}) { logged = append(logged, msg) })
+	n.MountCheck = MountCheckWarn
+	if err := n.ExportFs("/srv/unmounted/a", Anonymous, MountPoint("/srv/unmounted")); err != nil {
+		t.Errorf("Manager.ExportFs() with MountCheckWarn error = %v", err)
+	}
+	if len(logged) == 0 || logged[0] != "mount check failed" {
+		t.Errorf("logged %v, want a failed mount check", logged)
+	}
+	n.Logger = nil
+	if err := n.ExportFs("/srv/unmounted/a", Anonymous, MountPoint("/srv/unmounted")); err != nil {
+		t.Errorf("Manager.ExportFs() with MountCheckWarn and no logger error = %v", err)
+	}
+
+	n.MountCheck = MountCheckEnforce
+	if err := n.ExportFs("/srv/link", Anonymous, MountPoint("")); err != nil {
+		t.Errorf("Manager.ExportFs() of a symlinked mount point with MountCheckEnforce error = %v", err)
+	}
+	calls := len(fake.calls)
+	err := n.Batch().ExportFs("/srv/data", Anonymous).ExportFs("/srv/file", Anonymous).Commit()
+	if err == nil || !strings.Contains(err.Error(), "not a directory") {
+		t.Errorf("Batch.Commit() with MountCheckEnforce error = %v, want not a directory", err)
+	}
+	if len(fake.calls) != calls {
+		t.Errorf("exportfs calls = %v, want none after a failed check", fake.calls[calls:])
+	}
+}
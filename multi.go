@@ -0,0 +1,30 @@
+package nfsmanager
+
+import (
+	"context"
+)
+
+// ExportAll exports export.Path to each of export's clients with that
+// client's options, as a single line in exports(5) would. Clients the
+// path is already exported to but which are not listed are left alone.
+//
+// If exporting to one of the clients fails, the clients already
+// exported are rolled back: clients the path wasn't exported to before
+// are unexported and the others are exported again with their previous
+// options. The returned error is then a *RollbackError.
+//
+// A persistent manager adds all clients to its managed file in a single
+// update.
+func (n *Manager) ExportAll(export Export) error {
+	return n.ExportAllContext(context.Background(), export)
+}
+
+// ExportAllContext is like ExportAll, but stops exporting once ctx is
+// done. The rollback that follows is not bound by ctx.
+func (n *Manager) ExportAllContext(ctx context.Context, export Export) error {
+	b := n.Batch()
+	for _, client := range export.Clients {
+		b.ExportFs(export.Path, client.Client, client.Options...)
+	}
+	return b.CommitContext(ctx)
+}
@@ -0,0 +1,136 @@
+package nfsmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_nfsManager_ExportAll(t *testing.T) {
+	export := Export{"/srv/a", []ClientExport{
+		{Host("10.0.0.1"), []Option{RO}},
+		{Network("10.1.0.0/16"), []Option{RW, NoRootSquash}},
+	}}
+
+	fake := newFakeExportfs()
+	n := NFSManager()
+	n.commandRetrier = fake.run
+
+	if err := n.ExportAll(export); err != nil {
+		t.Fatalf("Manager.ExportAll() error = %v", err)
+	}
+	wantCalls := [][]string{
+		exportFSCommandLine("/srv/a", Host("10.0.0.1"), []Option{RO}),
+		exportFSCommandLine("/srv/a", Network("10.1.0.0/16"), []Option{RW, NoRootSquash}),
+	}
+	if !reflect.DeepEqual(fake.calls, wantCalls) {
+		t.Errorf("exportfs calls = %v, want %v", fake.calls, wantCalls)
+	}
+}
+
+func Test_nfsManager_ExportAll_rollback(t *testing.T) {
+	fake := newFakeExportfs(Export{"/srv/a", []ClientExport{{Host("10.0.0.1"), []Option{RW}}}})
+	n := NFSManager()
+	n.commandRetrier = fake.run
+
+	previous, err := n.ListExports()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake.failOn = "10.0.0.3"
+	err = n.ExportAll(Export{"/srv/a", []ClientExport{
+		{Host("10.0.0.1"), []Option{RO}},
+		{Host("10.0.0.2"), nil},
+		{Host("10.0.0.3"), nil},
+	}})
+	var rollbackErr *RollbackError
+	if !errors.As(err, &rollbackErr) || len(rollbackErr.RollbackErrors) != 0 {
+		t.Fatalf("Manager.ExportAll() error = %v, want complete rollback", err)
+	}
+
+	wantCalls := [][]string{
+		exportFSCommandLine("/srv/a", Host("10.0.0.1"), []Option{RO}),
+		exportFSCommandLine("/srv/a", Host("10.0.0.2"), nil),
+		exportFSCommandLine("/srv/a", Host("10.0.0.3"), nil),
+		unExportFSCommandLine("/srv/a", Host("10.0.0.2")),
+		exportFSCommandLine("/srv/a", Host("10.0.0.1"), previous[0].Clients[0].Options),
+	}
+	if !reflect.DeepEqual(fake.calls, wantCalls) {
+		t.Errorf("exportfs calls = %v, want %v", fake.calls, wantCalls)
+	}
+	if len(fake.table) != 1 {
+		t.Errorf("export table = %v, want only the original export", fake.table)
+	}
+}
+
+func Test_nfsManager_ExportAll_rollbackFailure(t *testing.T) {
+	fake := newFakeExportfs()
+	n := NFSManager()
+	n.commandRetrier = fake.run
+
+	err := n.ExportAll(Export{"/srv/a", []ClientExport{
+		{Host("10.0.0.1"), nil},
+		{Host("invalid-"), nil},
+	}})
+	if err == nil {
+		t.Fatalf("Manager.ExportAll() error = nil, want error")
+	}
+	if len(fake.calls) != 0 {
+		t.Errorf("exportfs calls = %v, want none for an invalid client", fake.calls)
+	}
+
+	fake.failOn = "10.0.0.2"
+	n.commandRetrier = func(ctx context.Context, cmdLine []string, command execCommander, privilege Privilege, logger Logger) ([]byte, error) {
+		if cmdLine[1] == "-u" {
+			return nil, fmt.Errorf("Mock rollback failure")
+		}
+		return fake.run(ctx, cmdLine, command, privilege, logger)
+	}
+	err = n.ExportAll(Export{"/srv/a", []ClientExport{
+		{Host("10.0.0.1"), nil},
+		{Host("10.0.0.2"), nil},
+	}})
+	var rollbackErr *RollbackError
+	if !errors.As(err, &rollbackErr) || len(rollbackErr.RollbackErrors) != 1 {
+		t.Fatalf("Manager.ExportAll() error = %v, want one rollback error", err)
+	}
+}
+
+func Test_nfsManager_persistentExportAll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nfsmanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	reloads := 0
+	n := persistentTestManager(t, dir, &reloads, nil)
+	if err := n.ExportFs("/srv/a", Host("10.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	err = n.ExportAll(Export{"/srv/a", []ClientExport{
+		{Host("10.0.0.1"), []Option{RO}},
+		{Host("10.0.0.2"), []Option{RW}},
+	}})
+	if err != nil {
+		t.Fatalf("Manager.ExportAll() error = %v", err)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(dir, "myapp.exports"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Managed by nfsmanager for myapp. Do not edit.\n/srv/a 10.0.0.1(ro) 10.0.0.2(rw)\n"
+	if string(got) != want {
+		t.Errorf("managed file = %q, want %q", got, want)
+	}
+	if reloads != 2 {
+		t.Errorf("exportfs -ra ran %d times, want 2", reloads)
+	}
+}
@@ -0,0 +1,325 @@
+package nfsmanager
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultNFSConfPath is where nfs-utils reads its server-wide settings
+// from.
+const DefaultNFSConfPath = "/etc/nfs.conf"
+
+// NFSConf is an nfs.conf(5) document: an INI file with sections such as
+// [nfsd], [mountd] and [exportfs].
+//
+// Like ExportsFile, lines that are not modified are written back exactly
+// as they were read, so comments, blank lines and keys this package
+// doesn't know survive a round trip.
+type NFSConf struct {
+	lines []*confLine
+
+	missingNewline bool
+}
+
+// confLine is a physical line of nfs.conf. Section is the section the
+// line is in, or the one it starts if it is a section header.
+type confLine struct {
+	raw     string
+	section string
+	header  bool
+	key     string
+	value   string
+}
+
+// ReadNFSConf reads and parses the nfs.conf file at path.
+func ReadNFSConf(path string) (*NFSConf, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseNFSConf(bytes.NewReader(data))
+}
+
+// ParseNFSConf parses an nfs.conf(5) document. Section and key names are
+// case insensitive; a subsection, as in [section "name"], is part of the
+// section name.
+func ParseNFSConf(r io.Reader) (*NFSConf, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &NFSConf{}
+	text := string(data)
+	if text == "" {
+		return c, nil
+	}
+	c.missingNewline = !strings.HasSuffix(text, "\n")
+	text = strings.TrimSuffix(text, "\n")
+
+	section := ""
+	for i, raw := range strings.Split(text, "\n") {
+		line := &confLine{raw: raw, section: section}
+		trimmed := strings.TrimSpace(raw)
+		switch {
+		case trimmed == "" || trimmed[0] == '#' || trimmed[0] == ';':
+		case trimmed[0] == '[':
+			end := strings.Index(trimmed, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("nfs.conf line %d: unterminated section header", i+1)
+			}
+			section = normalizeConfName(trimmed[1:end])
+			line.section, line.header = section, true
+		default:
+			eq := strings.Index(trimmed, "=")
+			if eq < 0 {
+				return nil, fmt.Errorf("nfs.conf line %d: expected key=value", i+1)
+			}
+			if section == "" {
+				return nil, fmt.Errorf("nfs.conf line %d: key outside of a section", i+1)
+			}
+			line.key = strings.ToLower(strings.TrimSpace(trimmed[:eq]))
+			line.value = unquoteConfValue(stripConfComment(strings.TrimSpace(trimmed[eq+1:])))
+		}
+		c.lines = append(c.lines, line)
+	}
+	return c, nil
+}
+
+func normalizeConfName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// stripConfComment removes a trailing comment that isn't quoted.
+func stripConfComment(value string) string {
+	inQuotes := false
+	for i, c := range value {
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+		case !inQuotes && (c == '#' || c == ';'):
+			return strings.TrimSpace(value[:i])
+		}
+	}
+	return value
+}
+
+func unquoteConfValue(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// Get returns the value of key in section. If the key is given more than
+// once, the last one wins, as it does for nfs-utils.
+func (c *NFSConf) Get(section, key string) (string, bool) {
+	section, key = normalizeConfName(section), strings.ToLower(key)
+	value, ok := "", false
+	for _, line := range c.lines {
+		if !line.header && line.section == section && line.key == key {
+			value, ok = line.value, true
+		}
+	}
+	return value, ok
+}
+
+// Set sets key in section to value. An existing key is changed in
+// place; otherwise the key is added at the end of the section, which is
+// added to the end of the document if it doesn't exist yet.
+func (c *NFSConf) Set(section, key, value string) {
+	section, key = normalizeConfName(section), strings.ToLower(key)
+	entry := &confLine{raw: key + "=" + value, section: section, key: key, value: value}
+	if strings.TrimSpace(value) != value || strings.ContainsAny(value, "#;") {
+		entry.raw = key + "=\"" + value + "\""
+	}
+
+	last := -1
+	for i, line := range c.lines {
+		if line.section != section {
+			continue
+		}
+		if !line.header && line.key == key {
+			last = i
+		}
+	}
+	if last >= 0 {
+		c.lines[last] = entry
+		return
+	}
+
+	end := -1
+	for i, line := range c.lines {
+		if line.section == section && (line.header || strings.TrimSpace(line.raw) != "") {
+			end = i
+		}
+	}
+	if end < 0 {
+		if len(c.lines) > 0 && strings.TrimSpace(c.lines[len(c.lines)-1].raw) != "" {
+			c.lines = append(c.lines, &confLine{section: c.lines[len(c.lines)-1].section})
+		}
+		c.lines = append(c.lines, &confLine{raw: "[" + section + "]", section: section, header: true}, entry)
+		return
+	}
+	c.lines = append(c.lines[:end+1], append([]*confLine{entry}, c.lines[end+1:]...)...)
+}
+
+// Unset removes every occurrence of key from section and reports whether
+// there was any.
+func (c *NFSConf) Unset(section, key string) bool {
+	section, key = normalizeConfName(section), strings.ToLower(key)
+	lines := c.lines[:0]
+	removed := false
+	for _, line := range c.lines {
+		if !line.header && line.section == section && line.key == key {
+			removed = true
+			continue
+		}
+		lines = append(lines, line)
+	}
+	c.lines = lines
+	return removed
+}
+
+// GetBool returns the boolean value of key in section. nfs-utils accepts
+// y, yes, t, true, on and 1 as true and n, no, f, false, off and 0 as
+// false.
+func (c *NFSConf) GetBool(section, key string) (value bool, ok bool, err error) {
+	s, ok := c.Get(section, key)
+	if !ok {
+		return false, false, nil
+	}
+	switch strings.ToLower(s) {
+	case "y", "yes", "t", "true", "on", "1":
+		return true, true, nil
+	case "n", "no", "f", "false", "off", "0":
+		return false, true, nil
+	}
+	return false, true, fmt.Errorf("[%s] %s: invalid boolean %q", section, key, s)
+}
+
+// SetBool sets key in section to y or n.
+func (c *NFSConf) SetBool(section, key string, value bool) {
+	if value {
+		c.Set(section, key, "y")
+	} else {
+		c.Set(section, key, "n")
+	}
+}
+
+// GetInt returns the integer value of key in section.
+func (c *NFSConf) GetInt(section, key string) (value int, ok bool, err error) {
+	s, ok := c.Get(section, key)
+	if !ok {
+		return 0, false, nil
+	}
+	value, err = strconv.Atoi(s)
+	if err != nil {
+		return 0, true, fmt.Errorf("[%s] %s: invalid number %q", section, key, s)
+	}
+	return value, true, nil
+}
+
+// SetInt sets key in section to value.
+func (c *NFSConf) SetInt(section, key string, value int) {
+	c.Set(section, key, strconv.Itoa(value))
+}
+
+// Threads returns the number of nfsd threads, [nfsd] threads.
+func (c *NFSConf) Threads() (int, bool, error) {
+	return c.GetInt("nfsd", "threads")
+}
+
+// SetThreads sets the number of nfsd threads.
+func (c *NFSConf) SetThreads(threads int) {
+	c.SetInt("nfsd", "threads", threads)
+}
+
+// Version returns whether nfsd serves NFS version, e.g. "3" or "4.2",
+// as set by [nfsd] vers3, vers4.2 etc.
+func (c *NFSConf) Version(version string) (bool, bool, error) {
+	return c.GetBool("nfsd", "vers"+version)
+}
+
+// SetVersion enables or disables NFS version, e.g. "3" or "4.2".
+func (c *NFSConf) SetVersion(version string, enabled bool) {
+	c.SetBool("nfsd", "vers"+version, enabled)
+}
+
+// RDMA returns whether nfsd listens for RDMA connections, [nfsd] rdma.
+func (c *NFSConf) RDMA() (bool, bool, error) {
+	return c.GetBool("nfsd", "rdma")
+}
+
+// SetRDMA enables or disables RDMA.
+func (c *NFSConf) SetRDMA(enabled bool) {
+	c.SetBool("nfsd", "rdma", enabled)
+}
+
+// Port returns the port the daemon configured by section, e.g. "nfsd",
+// "mountd", "statd" or "lockd", listens on.
+func (c *NFSConf) Port(section string) (int, bool, error) {
+	return c.GetInt(section, "port")
+}
+
+// SetPort sets the port the daemon configured by section listens on.
+func (c *NFSConf) SetPort(section string, port int) {
+	c.SetInt(section, "port", port)
+}
+
+// Bytes returns the document as it will be written.
+func (c *NFSConf) Bytes() []byte {
+	var b bytes.Buffer
+	for i, line := range c.lines {
+		b.WriteString(line.raw)
+		if i < len(c.lines)-1 || !c.missingNewline {
+			b.WriteByte('\n')
+		}
+	}
+	return b.Bytes()
+}
+
+// WriteTo writes the document to w.
+func (c *NFSConf) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(c.Bytes())
+	return int64(n), err
+}
+
+// UpdateNFSConf applies update to root's /etc/nfs.conf and writes it
+// back. If the server is running, it is restarted so that nfsd and
+// mountd pick up the new settings; exportfs reads its [exportfs]
+// section on every run.
+func (s *Server) UpdateNFSConf(update func(*NFSConf) error) error {
+	return s.UpdateNFSConfContext(context.Background(), update)
+}
+
+// UpdateNFSConfContext is like UpdateNFSConf, but the restart is killed
+// if ctx is done before it completes.
+func (s *Server) UpdateNFSConfContext(ctx context.Context, update func(*NFSConf) error) error {
+	path := rootedPath(s.Root, DefaultNFSConfPath)
+	conf, err := ReadNFSConf(path)
+	if os.IsNotExist(err) {
+		conf, err = &NFSConf{}, nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := update(conf); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, conf.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	active, err := s.ActiveContext(ctx)
+	if err != nil || !active {
+		return err
+	}
+	return s.RestartContext(ctx)
+}
@@ -0,0 +1,149 @@
+package nfsmanager
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testNFSConf = `#
+# This is a general configuration for the
+# NFS daemons and tools
+#
+[general]
+pipefs-directory=/run/rpc_pipefs
+#
+[exportfs]
+# debug=0
+#
+[nfsd]
+# debug=0
+ threads = 8 ; more on big boxes
+vers3=n
+# vers4.2=y
+rdma=y
+unknown-key = "quoted # value"
+
+[Mountd]
+port=20048`
+
+func TestParseNFSConf(t *testing.T) {
+	conf, err := ParseNFSConf(strings.NewReader(testNFSConf))
+	if err != nil {
+		t.Fatalf("ParseNFSConf() error = %v", err)
+	}
+	if got := string(conf.Bytes()); got != testNFSConf {
+		t.Errorf("NFSConf.Bytes() = %q, want the input unchanged", got)
+	}
+
+	if threads, ok, err := conf.Threads(); threads != 8 || !ok || err != nil {
+		t.Errorf("NFSConf.Threads() = %v, %v, %v, want 8", threads, ok, err)
+	}
+	if enabled, ok, err := conf.Version("3"); enabled || !ok || err != nil {
+		t.Errorf("NFSConf.Version(3) = %v, %v, %v, want false", enabled, ok, err)
+	}
+	if _, ok, _ := conf.Version("4.2"); ok {
+		t.Errorf("NFSConf.Version(4.2) is set, want it unset as it is commented out")
+	}
+	if enabled, ok, err := conf.RDMA(); !enabled || !ok || err != nil {
+		t.Errorf("NFSConf.RDMA() = %v, %v, %v, want true", enabled, ok, err)
+	}
+	if port, ok, err := conf.Port("mountd"); port != 20048 || !ok || err != nil {
+		t.Errorf("NFSConf.Port(mountd) = %v, %v, %v, want 20048", port, ok, err)
+	}
+	if value, _ := conf.Get("nfsd", "Unknown-Key"); value != "quoted # value" {
+		t.Errorf("NFSConf.Get(nfsd, unknown-key) = %q, want %q", value, "quoted # value")
+	}
+
+	for _, bad := range []string{"[nfsd\n", "[nfsd]\nthreads\n", "threads=8\n"} {
+		if _, err := ParseNFSConf(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParseNFSConf(%q) error = nil, want error", bad)
+		}
+	}
+}
+
+func TestNFSConf_Set(t *testing.T) {
+	conf, err := ParseNFSConf(strings.NewReader(testNFSConf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.SetThreads(32)
+	conf.SetVersion("4.2", true)
+	conf.SetRDMA(false)
+	conf.SetPort("nfsd", 2049)
+	conf.SetPort("statd", 662)
+	conf.Unset("nfsd", "vers3")
+
+	want := `#
+# This is a general configuration for the
+# NFS daemons and tools
+#
+[general]
+pipefs-directory=/run/rpc_pipefs
+#
+[exportfs]
+# debug=0
+#
+[nfsd]
+# debug=0
+threads=32
+# vers4.2=y
+rdma=n
+unknown-key = "quoted # value"
+vers4.2=y
+port=2049
+
+[Mountd]
+port=20048
+
+[statd]
+port=662`
+	if got := string(conf.Bytes()); got != want {
+		t.Errorf("NFSConf.Bytes() = %q, want %q", got, want)
+	}
+}
+
+func TestServer_UpdateNFSConf(t *testing.T) {
+	root, err := ioutil.TempDir("", "nfsmanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	writeTestFile(t, root, "etc/init.d/nfs-server", "")
+	writeTestFile(t, root, "etc/nfs.conf", "[nfsd]\nthreads=8\n")
+
+	var calls [][]string
+	s := serverTestManager(t, root, &calls, nil)
+	err = s.UpdateNFSConf(func(conf *NFSConf) error {
+		conf.SetThreads(16)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Server.UpdateNFSConf() error = %v", err)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(root, "etc/nfs.conf"))
+	if err != nil || string(got) != "[nfsd]\nthreads=16\n" {
+		t.Errorf("nfs.conf = %q, %v, want threads=16", got, err)
+	}
+	wantCalls := [][]string{{"/etc/init.d/nfs-server", "status"}, {"/etc/init.d/nfs-server", "restart"}}
+	if !reflect.DeepEqual(calls, wantCalls) {
+		t.Errorf("commands = %v, want %v", calls, wantCalls)
+	}
+
+	calls = nil
+	s.commandRetrier = func(ctx context.Context, cmdLine []string, command execCommander, privilege Privilege, logger Logger) ([]byte, error) {
+		calls = append(calls, cmdLine)
+		return nil, &ExportError{ExitCode: 3, Err: fmt.Errorf("exit status 3")}
+	}
+	if err := s.UpdateNFSConf(func(conf *NFSConf) error { return nil }); err != nil {
+		t.Fatalf("Server.UpdateNFSConf() error = %v", err)
+	}
+	if len(calls) != 1 {
+		t.Errorf("commands = %v, want only the status check for a stopped server", calls)
+	}
+}
@@ -0,0 +1,233 @@
+// Package nfstest provides an in-memory nfsmanager.Exporter, for testing
+// code that manages exports without running exportfs.
+package nfstest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/sorenisanerd/nfsmanager"
+)
+
+// Op identifies the operation a Call made.
+type Op int
+
+const (
+	// OpExport is ExportFs and ExportFsContext.
+	OpExport Op = iota
+	// OpUnExport is UnExportFs and UnExportFsContext.
+	OpUnExport
+	// OpList is ListExports and ListExportsContext.
+	OpList
+	// OpReload is Reload and ReloadContext.
+	OpReload
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpExport:
+		return "export"
+	case OpUnExport:
+		return "unexport"
+	case OpList:
+		return "list"
+	case OpReload:
+		return "reload"
+	default:
+		return fmt.Sprintf("Op(%d)", int(op))
+	}
+}
+
+// Call records an operation made on a Fake. Path, Client and Options
+// are only set for the operations that take them.
+type Call struct {
+	Op      Op
+	Path    string
+	Client  nfsmanager.Client
+	Options []nfsmanager.Option
+}
+
+type key struct {
+	path   string
+	client nfsmanager.Client
+}
+
+// Fake keeps an export table in memory and changes it the way exportfs
+// changes the kernel's: exporting a path to a client it is already
+// exported to replaces its options, and unexporting a path from a client
+// it isn't exported to is an error. Options are reported back by
+// ListExports as they were given, without exportfs' defaults.
+//
+// A Fake is safe for concurrent use.
+type Fake struct {
+	// Fault, if set, is called before each operation. If it returns an
+	// error, the operation fails with that error without changing the
+	// export table.
+	Fault func(Call) error
+
+	mu    sync.Mutex
+	order []key
+	table map[key][]nfsmanager.Option
+	calls []Call
+}
+
+var _ nfsmanager.Exporter = (*Fake)(nil)
+
+// NewFake returns a Fake whose export table holds exports.
+func NewFake(exports ...nfsmanager.Export) *Fake {
+	f := &Fake{table: make(map[key][]nfsmanager.Option)}
+	for _, export := range exports {
+		for _, client := range export.Clients {
+			f.set(key{export.Path, client.Client}, client.Options)
+		}
+	}
+	return f
+}
+
+// FailOn makes operations op on path fail with err. An empty path
+// matches every path.
+func (f *Fake) FailOn(op Op, path string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Fault = func(call Call) error {
+		if call.Op == op && (path == "" || call.Path == path) {
+			return err
+		}
+		return nil
+	}
+}
+
+// Calls returns the operations made on f so far, including failed ones.
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+func (f *Fake) set(k key, options []nfsmanager.Option) {
+	if _, ok := f.table[k]; !ok {
+		f.order = append(f.order, k)
+	}
+	f.table[k] = append([]nfsmanager.Option(nil), options...)
+}
+
+// begin records call and reports whether it may proceed. validate, if
+// not nil, checks the call's arguments after it is recorded.
+func (f *Fake) begin(ctx context.Context, call Call, validate func() error) error {
+	f.calls = append(f.calls, call)
+	if validate != nil {
+		if err := validate(); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", call.Op, err)
+	}
+	if f.Fault != nil {
+		return f.Fault(call)
+	}
+	return nil
+}
+
+// ExportFs exports path to client with options.
+func (f *Fake) ExportFs(path string, client nfsmanager.Client, options ...nfsmanager.Option) error {
+	return f.ExportFsContext(context.Background(), path, client, options...)
+}
+
+// ExportFsContext is like ExportFs, but fails if ctx is done.
+func (f *Fake) ExportFsContext(ctx context.Context, path string, client nfsmanager.Client, options ...nfsmanager.Option) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	validate := func() error {
+		if err := client.Validate(); err != nil {
+			return err
+		}
+		return nfsmanager.ValidateOptions(options...)
+	}
+	if err := f.begin(ctx, Call{Op: OpExport, Path: path, Client: client, Options: options}, validate); err != nil {
+		return err
+	}
+	f.set(key{path, client}, options)
+	return nil
+}
+
+// UnExportFs unexports path from client.
+func (f *Fake) UnExportFs(path string, client nfsmanager.Client) error {
+	return f.UnExportFsContext(context.Background(), path, client)
+}
+
+// UnExportFsContext is like UnExportFs, but fails if ctx is done.
+func (f *Fake) UnExportFsContext(ctx context.Context, path string, client nfsmanager.Client) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin(ctx, Call{Op: OpUnExport, Path: path, Client: client}, client.Validate); err != nil {
+		return err
+	}
+
+	k := key{path, client}
+	if _, ok := f.table[k]; !ok {
+		spec := client.String() + ":" + path
+		return &nfsmanager.ExportError{
+			CommandLine: []string{"exportfs", "-u", spec},
+			ExitCode:    1,
+			Stderr:      []byte(fmt.Sprintf("exportfs: Could not find '%s' to unexport.\n", spec)),
+			// exportfs' message matches none of the specific causes.
+			Cause: nfsmanager.CauseUnknown,
+			Err:   errors.New("exit status 1"),
+		}
+	}
+	delete(f.table, k)
+	order := f.order[:0]
+	for _, o := range f.order {
+		if o != k {
+			order = append(order, o)
+		}
+	}
+	f.order = order
+	return nil
+}
+
+// ListExports returns the export table, grouped by path in the order
+// the paths were first exported.
+func (f *Fake) ListExports() ([]nfsmanager.Export, error) {
+	return f.ListExportsContext(context.Background())
+}
+
+// ListExportsContext is like ListExports, but fails if ctx is done.
+func (f *Fake) ListExportsContext(ctx context.Context) ([]nfsmanager.Export, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin(ctx, Call{Op: OpList}, nil); err != nil {
+		return nil, err
+	}
+
+	var exports []nfsmanager.Export
+	index := make(map[string]int)
+	for _, k := range f.order {
+		i, ok := index[k.path]
+		if !ok {
+			i = len(exports)
+			index[k.path] = i
+			exports = append(exports, nfsmanager.Export{Path: k.path})
+		}
+		client := nfsmanager.ClientExport{Client: k.client, Options: append([]nfsmanager.Option(nil), f.table[k]...)}
+		exports[i].Clients = append(exports[i].Clients, client)
+	}
+	return exports, nil
+}
+
+// Reload does nothing to the export table, which has no exports(5)
+// files behind it, but is recorded and subject to Fault like the other
+// operations.
+func (f *Fake) Reload() error {
+	return f.ReloadContext(context.Background())
+}
+
+// ReloadContext is like Reload, but fails if ctx is done.
+func (f *Fake) ReloadContext(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.begin(ctx, Call{Op: OpReload}, nil)
+}
@@ -0,0 +1,98 @@
+package nfstest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/sorenisanerd/nfsmanager"
+)
+
+func TestFake(t *testing.T) {
+	f := NewFake(nfsmanager.Export{Path: "/srv/a", Clients: []nfsmanager.ClientExport{
+		{Client: nfsmanager.Host("10.0.0.1"), Options: []nfsmanager.Option{nfsmanager.RW}},
+	}})
+
+	if err := f.ExportFs("/srv/b", nfsmanager.Anonymous, nfsmanager.RO); err != nil {
+		t.Fatalf("Fake.ExportFs() error = %v", err)
+	}
+	if err := f.ExportFs("/srv/a", nfsmanager.Host("10.0.0.1"), nfsmanager.RO); err != nil {
+		t.Fatalf("Fake.ExportFs() error = %v", err)
+	}
+	if err := f.ExportFs("/srv/a", nfsmanager.Host("10.0.0.2")); err != nil {
+		t.Fatalf("Fake.ExportFs() error = %v", err)
+	}
+
+	got, err := f.ListExports()
+	if err != nil {
+		t.Fatalf("Fake.ListExports() error = %v", err)
+	}
+	want := []nfsmanager.Export{
+		{Path: "/srv/a", Clients: []nfsmanager.ClientExport{
+			{Client: nfsmanager.Host("10.0.0.1"), Options: []nfsmanager.Option{nfsmanager.RO}},
+			{Client: nfsmanager.Host("10.0.0.2")},
+		}},
+		{Path: "/srv/b", Clients: []nfsmanager.ClientExport{
+			{Client: nfsmanager.Anonymous, Options: []nfsmanager.Option{nfsmanager.RO}},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Fake.ListExports() = %v, want %v", got, want)
+	}
+
+	if err := f.UnExportFs("/srv/a", nfsmanager.Host("10.0.0.1")); err != nil {
+		t.Fatalf("Fake.UnExportFs() error = %v", err)
+	}
+	err = f.UnExportFs("/srv/a", nfsmanager.Host("10.0.0.1"))
+	var exportErr *nfsmanager.ExportError
+	if !errors.As(err, &exportErr) {
+		t.Errorf("Fake.UnExportFs() of a missing export error = %v, want *ExportError", err)
+	} else if exportErr.Cause != nfsmanager.CauseUnknown {
+		t.Errorf("Fake.UnExportFs() of a missing export cause = %v, want %v", exportErr.Cause, nfsmanager.CauseUnknown)
+	}
+
+	if err := f.ExportFs("/srv/c", nfsmanager.Host("10.0.0.1"), nfsmanager.RW, nfsmanager.RO); err == nil {
+		t.Errorf("Fake.ExportFs() with contradicting options error = nil, want error")
+	}
+	calls := f.Calls()
+	if n := len(calls); n != 7 {
+		t.Fatalf("Fake.Calls() holds %d calls, want 7", n)
+	}
+	if last := calls[6]; last.Op != OpExport || last.Path != "/srv/c" {
+		t.Errorf("Fake.Calls() ends with %v, want the invalid export of /srv/c", last)
+	}
+}
+
+func TestFake_faults(t *testing.T) {
+	f := NewFake()
+	mockErr := fmt.Errorf("Mock failure")
+	f.FailOn(OpExport, "/srv/b", mockErr)
+
+	if err := f.ExportFs("/srv/a", nfsmanager.Anonymous); err != nil {
+		t.Errorf("Fake.ExportFs() error = %v", err)
+	}
+	if err := f.ExportFs("/srv/b", nfsmanager.Anonymous); err != mockErr {
+		t.Errorf("Fake.ExportFs() error = %v, want %v", err, mockErr)
+	}
+	if exports, _ := f.ListExports(); len(exports) != 1 {
+		t.Errorf("Fake.ListExports() = %v, want only /srv/a", exports)
+	}
+
+	f.Fault = func(call Call) error {
+		if call.Op == OpReload {
+			return mockErr
+		}
+		return nil
+	}
+	if err := f.Reload(); err != mockErr {
+		t.Errorf("Fake.Reload() error = %v, want %v", err, mockErr)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := f.ListExportsContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Fake.ListExportsContext() error = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,147 @@
+package nfsmanager
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// flagOptions are the options that never take a value.
+var flagOptions = []Option{
+	Secure, InSecure, RW, RO, ASync, Sync, NoWDelay, WDelay, NoHide, Hide,
+	CrossMnt, NoCrossMnt, NoSubtreeCheck, SubtreeCheck, InsecureLocks,
+	NoAuthNLM, SecureLocks, AuthNLM, NoRDirPlus, RDirPlus, NoACL, ACL,
+	SecurityLabel, PNFS, NoPNFS, RootSquash, NoRootSquash, AllSquash,
+	NoAllSquash,
+}
+
+// valueOptions builds the options that take a value. hasValue is false
+// if the option was given without "=".
+var valueOptions = map[string]func(value string, hasValue bool) (Option, error){
+	"mountpoint": func(value string, hasValue bool) (Option, error) {
+		if hasValue && value == "" {
+			return Option{}, fmt.Errorf("empty path")
+		}
+		return MountPoint(value), nil
+	},
+	"mp": func(value string, hasValue bool) (Option, error) {
+		if hasValue && value == "" {
+			return Option{}, fmt.Errorf("empty path")
+		}
+		return MP(value), nil
+	},
+	"fsid": func(value string, hasValue bool) (Option, error) {
+		if value == "" {
+			return Option{}, fmt.Errorf("missing value")
+		}
+		return FsID(value), nil
+	},
+	"refer": func(value string, hasValue bool) (Option, error) {
+		if value == "" {
+			return Option{}, fmt.Errorf("missing locations")
+		}
+		return Refer(strings.Split(value, ":")...), nil
+	},
+	"replicas": func(value string, hasValue bool) (Option, error) {
+		if value == "" {
+			return Option{}, fmt.Errorf("missing locations")
+		}
+		return Replicas(strings.Split(value, ":")...), nil
+	},
+	"sec": func(value string, hasValue bool) (Option, error) {
+		if value == "" {
+			return Option{}, fmt.Errorf("missing flavors")
+		}
+		return Sec(strings.Split(value, ":")...), nil
+	},
+	"xprtsec": func(value string, hasValue bool) (Option, error) {
+		if value == "" {
+			return Option{}, fmt.Errorf("missing policies")
+		}
+		return XprtSec(strings.Split(value, ":")...), nil
+	},
+	"anonuid": func(value string, hasValue bool) (Option, error) {
+		uid, err := strconv.Atoi(value)
+		if err != nil {
+			return Option{}, fmt.Errorf("invalid uid %q", value)
+		}
+		return AnonUID(uid), nil
+	},
+	"anongid": func(value string, hasValue bool) (Option, error) {
+		gid, err := strconv.Atoi(value)
+		if err != nil {
+			return Option{}, fmt.Errorf("invalid gid %q", value)
+		}
+		return AnonGID(gid), nil
+	},
+}
+
+// synonyms maps alternative option names to the name exportfs reports
+// them by.
+var synonyms = map[string]string{
+	"mp":          "mountpoint",
+	"no_auth_nlm": "insecure_locks",
+	"auth_nlm":    "secure_locks",
+}
+
+// ParseOptions parses a comma separated option list as accepted by
+// exportfs -o, e.g. "rw,sync,fsid=7,refer=/a@h1:/b@h2", into options.
+//
+// Synonyms are returned as the option they were spelled as, so MP("")
+// for "mp" and NoAuthNLM for "no_auth_nlm". Unknown options, options
+// given a value they don't take and malformed values are errors.
+func ParseOptions(s string) ([]Option, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var options []Option
+	for _, field := range strings.Split(s, ",") {
+		opt, err := parseOption(field)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, opt)
+	}
+	return options, nil
+}
+
+func parseOption(field string) (Option, error) {
+	if field == "" {
+		return Option{}, fmt.Errorf("empty option")
+	}
+
+	name, value, hasValue := field, "", false
+	if i := strings.Index(field, "="); i >= 0 {
+		name, value, hasValue = field[:i], field[i+1:], true
+	}
+
+	if build, ok := valueOptions[name]; ok {
+		opt, err := build(value, hasValue)
+		if err != nil {
+			return Option{}, fmt.Errorf("option %q: %w", field, err)
+		}
+		return opt, nil
+	}
+
+	for _, opt := range flagOptions {
+		if opt.optionString != name {
+			continue
+		}
+		if hasValue {
+			return Option{}, fmt.Errorf("option %q does not take a value", name)
+		}
+		return opt, nil
+	}
+
+	return Option{}, fmt.Errorf("unknown option %q", name)
+}
+
+// canonical returns opt spelled the way exportfs reports it, so that
+// synonymous options compare equal.
+func (opt Option) canonical() Option {
+	if name, ok := synonyms[opt.optionString]; ok {
+		opt.optionString = name
+	}
+	return opt
+}
@@ -0,0 +1,76 @@
+package nfsmanager
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []Option
+		wantErr bool
+	}{
+		{"Empty", "", nil, false},
+		{"Flags", "rw,sync,no_root_squash", []Option{RW, Sync, NoRootSquash}, false},
+		{"All flags", "secure,insecure,rw,ro,async,sync,no_wdelay,wdelay,nohide,hide,crossmnt,nocrossmnt,no_subtree_check,subtree_check,insecure_locks,no_auth_nlm,secure_locks,auth_nlm,nordirplus,rdirplus,no_acl,acl,security_label,pnfs,no_pnfs,root_squash,no_root_squash,all_squash,no_all_squash",
+			[]Option{Secure, InSecure, RW, RO, ASync, Sync, NoWDelay, WDelay, NoHide, Hide, CrossMnt, NoCrossMnt, NoSubtreeCheck, SubtreeCheck, InsecureLocks, NoAuthNLM, SecureLocks, AuthNLM, NoRDirPlus, RDirPlus, NoACL, ACL, SecurityLabel, PNFS, NoPNFS, RootSquash, NoRootSquash, AllSquash, NoAllSquash}, false},
+		{"FsID root", "fsid=root", []Option{FsIDRoot}, false},
+		{"Sec", "sec=krb5p:sys", []Option{Sec("krb5p", "sys")}, false},
+		{"XprtSec", "xprtsec=tls", []Option{XprtSec("tls")}, false},
+		{"Sec without flavors", "sec=", nil, true},
+		{"FsID", "fsid=7", []Option{FsID("7")}, false},
+		{"Refer", "refer=/a@h1:/b@h2", []Option{Refer("/a@h1", "/b@h2")}, false},
+		{"Replicas", "replicas=/a@h1", []Option{Replicas("/a@h1")}, false},
+		{"MountPoint without path", "mountpoint", []Option{MountPoint("")}, false},
+		{"MountPoint with path", "mountpoint=/mnt", []Option{MountPoint("/mnt")}, false},
+		{"MP synonym", "mp=/mnt", []Option{MP("/mnt")}, false},
+		{"NoAuthNLM synonym", "no_auth_nlm", []Option{NoAuthNLM}, false},
+		{"AnonUID and AnonGID", "anonuid=1234,anongid=2345", []Option{AnonUID(1234), AnonGID(2345)}, false},
+		{"Round trip", "rw,sync,fsid=7,refer=/a@h1:/b@h2", []Option{RW, Sync, FsID("7"), Refer("/a@h1", "/b@h2")}, false},
+		{"Unknown option", "rw,bogus", nil, true},
+		{"Empty option", "rw,,sync", nil, true},
+		{"Flag with value", "rw=1", nil, true},
+		{"FsID without value", "fsid", nil, true},
+		{"FsID with empty value", "fsid=", nil, true},
+		{"Refer without locations", "refer=", nil, true},
+		{"MountPoint with empty path", "mp=", nil, true},
+		{"AnonUID not a number", "anonuid=nobody", nil, true},
+		{"AnonGID missing value", "anongid", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseOptions(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseOptions() = %v, want %v", got, tt.want)
+			}
+			if err == nil && optionsString(got) != tt.s {
+				t.Errorf("optionsString(ParseOptions()) = %v, want %v", optionsString(got), tt.s)
+			}
+		})
+	}
+}
+
+func Test_nfsOption_canonical(t *testing.T) {
+	tests := []struct {
+		name   string
+		option Option
+		want   Option
+	}{
+		{"MP", MP("/mnt"), MountPoint("/mnt")},
+		{"NoAuthNLM", NoAuthNLM, InsecureLocks},
+		{"AuthNLM", AuthNLM, SecureLocks},
+		{"Not a synonym", RW, RW},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.option.canonical(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("option.canonical() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
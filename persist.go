@@ -0,0 +1,247 @@
+package nfsmanager
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultExportsDir is the directory exportfs reads *.exports drop-in
+// files from in addition to /etc/exports.
+const DefaultExportsDir = "/etc/exports.d"
+
+func reloadCommandLine() []string {
+	return []string{"exportfs", "-ra"}
+}
+
+// Persistent returns a copy of n that persists exports instead of only
+// changing the kernel's export table.
+//
+// ExportFs and UnExportFs on the returned manager edit a file owned by
+// owner, <ExportsDir>/<owner>.exports, and then reload the export table
+// with `exportfs -ra`. If the reload fails, the file's previous content
+// is restored and reloaded, and the error is a *RollbackError. Exports
+// listed in other files are never touched.
+func (n *Manager) Persistent(owner string) (*Manager, error) {
+	if owner == "" || strings.ContainsAny(owner, "/\x00") || strings.HasPrefix(owner, ".") {
+		return nil, fmt.Errorf("invalid owner %q", owner)
+	}
+	p := *n
+	p.Owner = owner
+	if p.ExportsDir == "" {
+		p.ExportsDir = DefaultExportsDir
+	}
+	return &p, nil
+}
+
+// Reload re-exports all directories listed in /etc/exports and
+// /etc/exports.d, removing exports that are no longer listed.
+func (n *Manager) Reload() error {
+	return n.ReloadContext(context.Background())
+}
+
+// ReloadContext is like Reload, but exportfs is killed if ctx is done
+// before it completes.
+func (n *Manager) ReloadContext(ctx context.Context) error {
+	return n.reload(ctx)
+}
+
+// PersistedExports returns the exports in the managed file. It returns
+// no exports if the manager is not persistent or the file doesn't exist
+// yet.
+func (n *Manager) PersistedExports() ([]Export, error) {
+	if n.Owner == "" {
+		return nil, nil
+	}
+	f, err := n.readManagedFile()
+	if err != nil {
+		return nil, err
+	}
+	return f.Exports(), nil
+}
+
+func (n *Manager) managedFilePath() string {
+	return filepath.Join(n.ExportsDir, n.Owner+".exports")
+}
+
+func (n *Manager) readManagedFile() (*ExportsFile, error) {
+	if n.plan != nil {
+		// Build on the content earlier steps of the plan would have
+		// written rather than on the file as it is.
+		for i := len(n.plan.Steps) - 1; i >= 0; i-- {
+			if step := n.plan.Steps[i]; step.File == n.managedFilePath() {
+				return ParseExportsFile(bytes.NewReader(step.Content))
+			}
+		}
+	}
+
+	f, err := ReadExportsFile(n.managedFilePath())
+	if os.IsNotExist(err) {
+		return ParseExportsFile(strings.NewReader(fmt.Sprintf("# Managed by nfsmanager for %s. Do not edit.\n", n.Owner)))
+	}
+	return f, err
+}
+
+// persistExport adds clients to the export of path in the managed
+// file, replacing the options of clients that are already there.
+func (n *Manager) persistExport(ctx context.Context, path string, clients ...ClientExport) error {
+	return n.updateManagedFile(ctx, func(f *ExportsFile) error {
+		setClients(f, path, clients...)
+		return nil
+	})
+}
+
+func (n *Manager) unpersistExport(ctx context.Context, path string, host Client) error {
+	return n.updateManagedFile(ctx, func(f *ExportsFile) error {
+		if !removeClient(f, path, host) {
+			return fmt.Errorf("%s:%s is not exported by %s", host, path, n.managedFilePath())
+		}
+		return nil
+	})
+}
+
+// mergedExport returns the export of path in f, merging the clients of
+// all lines for path. It reports whether there was any such line.
+func mergedExport(f *ExportsFile, path string) (Export, bool) {
+	export := Export{Path: path}
+	found := false
+	for _, e := range f.Exports() {
+		if e.Path != path {
+			continue
+		}
+		found = true
+		for _, client := range e.Clients {
+			export.Clients = withClient(export.Clients, client)
+		}
+	}
+	return export, found
+}
+
+// withClient replaces the options of client in clients, or adds it.
+func withClient(clients []ClientExport, client ClientExport) []ClientExport {
+	for i := range clients {
+		if clients[i].Client == client.Client {
+			clients[i] = client
+			return clients
+		}
+	}
+	return append(clients, client)
+}
+
+// setClients adds clients to the export of path, replacing the options
+// of clients that are already there. All lines for path are merged into
+// one.
+func setClients(f *ExportsFile, path string, clients ...ClientExport) {
+	export, _ := mergedExport(f, path)
+	for _, client := range clients {
+		export.Clients = withClient(export.Clients, client)
+	}
+	f.Set(export)
+}
+
+// removeClient removes host from the export of path, and the export if
+// host was its last client. It reports whether host was found.
+func removeClient(f *ExportsFile, path string, host Client) bool {
+	export, found := mergedExport(f, path)
+	if !found {
+		return false
+	}
+	clients := export.Clients[:0]
+	for _, client := range export.Clients {
+		if client.Client != host {
+			clients = append(clients, client)
+		}
+	}
+	if len(clients) == len(export.Clients) {
+		return false
+	}
+	if len(clients) == 0 {
+		f.Remove(path)
+	} else {
+		export.Clients = clients
+		f.Set(export)
+	}
+	return true
+}
+
+func (n *Manager) updateManagedFile(ctx context.Context, update func(*ExportsFile) error) error {
+	f, err := n.readManagedFile()
+	if err != nil {
+		return err
+	}
+	if err := update(f); err != nil {
+		return err
+	}
+	if n.plan != nil {
+		n.plan.Steps = append(n.plan.Steps, PlanStep{File: n.managedFilePath(), Content: f.Bytes()})
+		return n.ReloadContext(ctx)
+	}
+
+	previous, err := ioutil.ReadFile(n.managedFilePath())
+	existed := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := writeFileAtomic(n.managedFilePath(), f.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := n.ReloadContext(ctx); err != nil {
+		return &RollbackError{Err: err, RollbackErrors: n.restoreManagedFile(previous, existed)}
+	}
+	return nil
+}
+
+// restoreManagedFile puts back the previous content of the managed
+// file, or removes it if it didn't exist, and reloads the export table
+// again. Like restore, it is not bound by the caller's context.
+func (n *Manager) restoreManagedFile(previous []byte, existed bool) []error {
+	var err error
+	if existed {
+		err = writeFileAtomic(n.managedFilePath(), previous, 0644)
+	} else {
+		err = os.Remove(n.managedFilePath())
+	}
+	if err != nil {
+		return []error{err}
+	}
+	if err := n.ReloadContext(context.Background()); err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file at path with data by writing it to
+// a temporary file in the same directory and renaming it into place, so
+// readers see either the old or the new content, never a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, "."+base+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,205 @@
+package nfsmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNFSManager_Persistent(t *testing.T) {
+	tests := []struct {
+		name    string
+		owner   string
+		wantErr bool
+	}{
+		{"Valid", "myapp", false},
+		{"Empty", "", true},
+		{"Slash", "../etc/passwd", true},
+		{"Hidden", ".myapp", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NFSManager().Persistent(tt.owner)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Manager.Persistent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && p.managedFilePath() != "/etc/exports.d/myapp.exports" {
+				t.Errorf("managedFilePath() = %v, want %v", p.managedFilePath(), "/etc/exports.d/myapp.exports")
+			}
+		})
+	}
+}
+
+func persistentTestManager(t *testing.T, dir string, reloads *int, reloadErr error) *Manager {
+	n := NFSManager()
+	n.ExportsDir = dir
+	n, err := n.Persistent("myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.commandRetrier = func(ctx context.Context, cmdLine []string, command execCommander, privilege Privilege, logger Logger) ([]byte, error) {
+		if want := reloadCommandLine(); !reflect.DeepEqual(want, cmdLine) {
+			t.Errorf("Got cmdLine = %v, wanted %v", cmdLine, want)
+		}
+		*reloads++
+		return nil, reloadErr
+	}
+	return n
+}
+
+func Test_nfsManager_persistentExportFs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nfsmanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	reloads := 0
+	n := persistentTestManager(t, dir, &reloads, nil)
+
+	steps := []struct {
+		name    string
+		do      func() error
+		want    string
+		wantErr bool
+	}{
+		{"Export", func() error { return n.ExportFs("/srv/a", Host("10.0.0.1"), RW) },
+			"# Managed by nfsmanager for myapp. Do not edit.\n/srv/a 10.0.0.1(rw)\n", false},
+		{"Export second client", func() error { return n.ExportFs("/srv/a", Host("10.0.0.2")) },
+			"# Managed by nfsmanager for myapp. Do not edit.\n/srv/a 10.0.0.1(rw) 10.0.0.2\n", false},
+		{"Re-export replaces options", func() error { return n.ExportFs("/srv/a", Host("10.0.0.1"), NoRootSquash) },
+			"# Managed by nfsmanager for myapp. Do not edit.\n/srv/a 10.0.0.1(no_root_squash) 10.0.0.2\n", false},
+		{"Unexport client", func() error { return n.UnExportFs("/srv/a", Host("10.0.0.1")) },
+			"# Managed by nfsmanager for myapp. Do not edit.\n/srv/a 10.0.0.2\n", false},
+		{"Unexport missing client", func() error { return n.UnExportFs("/srv/a", Host("10.0.0.1")) },
+			"# Managed by nfsmanager for myapp. Do not edit.\n/srv/a 10.0.0.2\n", true},
+		{"Unexport last client", func() error { return n.UnExportFs("/srv/a", Host("10.0.0.2")) },
+			"# Managed by nfsmanager for myapp. Do not edit.\n", false},
+	}
+	for _, step := range steps {
+		if err := step.do(); (err != nil) != step.wantErr {
+			t.Fatalf("%s: error = %v, wantErr %v", step.name, err, step.wantErr)
+		}
+		got, err := ioutil.ReadFile(filepath.Join(n.ExportsDir, "myapp.exports"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != step.want {
+			t.Errorf("%s: managed file = %q, want %q", step.name, got, step.want)
+		}
+	}
+	if reloads != 5 {
+		t.Errorf("exportfs -ra ran %d times, want 5", reloads)
+	}
+
+	exports, err := n.PersistedExports()
+	if err != nil || len(exports) != 0 {
+		t.Errorf("Manager.PersistedExports() = %v, %v, want no exports", exports, err)
+	}
+}
+
+func Test_nfsManager_persistentMultiLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nfsmanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	reloads := 0
+	n := persistentTestManager(t, dir, &reloads, nil)
+	path := filepath.Join(dir, "myapp.exports")
+	header := "# Managed by nfsmanager for myapp. Do not edit.\n"
+	content := header + "/srv a(rw)\n/srv b(ro)\n"
+
+	tests := []struct {
+		name string
+		do   func() error
+		want string
+	}{
+		{"Unexport first line", func() error { return n.UnExportFs("/srv", Host("a")) }, header + "/srv b(ro)\n"},
+		{"Unexport later line", func() error { return n.UnExportFs("/srv", Host("b")) }, header + "/srv a(rw)\n"},
+		{"Export new client", func() error { return n.ExportFs("/srv", Host("c")) }, header + "/srv a(rw) b(ro) c\n"},
+		{"Re-export later client", func() error { return n.ExportFs("/srv", Host("b"), RW) }, header + "/srv a(rw) b(rw)\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.do(); err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if got, _ := ioutil.ReadFile(path); string(got) != tt.want {
+				t.Errorf("managed file = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_nfsManager_persistentReloadFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nfsmanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	reloads := 0
+	n := persistentTestManager(t, dir, &reloads, fmt.Errorf("Mock failure"))
+	path := filepath.Join(dir, "myapp.exports")
+
+	// The file didn't exist, so it is removed again.
+	err = n.ExportFs("/srv/a", Host("10.0.0.1"))
+	var rollbackErr *RollbackError
+	if !errors.As(err, &rollbackErr) {
+		t.Fatalf("Manager.ExportFs() error = %v, want a *RollbackError", err)
+	}
+	if len(rollbackErr.RollbackErrors) != 1 {
+		t.Errorf("RollbackErrors = %v, want the failed reload", rollbackErr.RollbackErrors)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("managed file left behind, Stat() error = %v", err)
+	}
+	if reloads != 2 {
+		t.Errorf("exportfs -ra ran %d times, want 2", reloads)
+	}
+
+	want := "# Managed by nfsmanager for myapp. Do not edit.\n/srv/b 10.0.0.1\n"
+	if err := ioutil.WriteFile(path, []byte(want), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.UnExportFs("/srv/b", Host("10.0.0.1")); !errors.As(err, &rollbackErr) {
+		t.Fatalf("Manager.UnExportFs() error = %v, want a *RollbackError", err)
+	}
+	if got, _ := ioutil.ReadFile(path); string(got) != want {
+		t.Errorf("managed file = %q, want it restored to %q", got, want)
+	}
+}
+
+func Test_writeFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nfsmanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "file")
+	for _, content := range []string{"first\n", "second\n"} {
+		if err := writeFileAtomic(path, []byte(content), 0644); err != nil {
+			t.Fatalf("writeFileAtomic() error = %v", err)
+		}
+		got, err := ioutil.ReadFile(path)
+		if err != nil || string(got) != content {
+			t.Errorf("ReadFile() = %q, %v, want %q", got, err, content)
+		}
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want 1 (temporary file left behind?)", len(entries))
+	}
+}
@@ -0,0 +1,82 @@
+package nfsmanager
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Plan lists the steps a dry-run manager would have taken, in order.
+type Plan struct {
+	Steps []PlanStep
+}
+
+// PlanStep is a single step of a Plan. It either runs a command or
+// writes a file: the managed file of a persistent manager, the fsid
+// state file, or a cache file of the Kernel backend.
+type PlanStep struct {
+	// CommandLine is the command that would have been run.
+	CommandLine []string
+	// Fallback is the command that would have been run had CommandLine
+	// failed for lack of privileges.
+	Fallback []string
+
+	// File is the path of the file that would have been written.
+	File string
+	// Content is what File would have been replaced with, or for a
+	// kernel cache file, what would have been written to it.
+	Content []byte
+}
+
+func (s PlanStep) String() string {
+	if s.File != "" {
+		return fmt.Sprintf("write %s:\n%s", s.File, s.Content)
+	}
+	if len(s.Fallback) > 0 {
+		return fmt.Sprintf("%s (on failure: %s)", strings.Join(s.CommandLine, " "), strings.Join(s.Fallback, " "))
+	}
+	return strings.Join(s.CommandLine, " ")
+}
+
+func (p *Plan) String() string {
+	var lines []string
+	for _, step := range p.Steps {
+		lines = append(lines, step.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// CommandLines returns the command lines of the plan's command steps.
+func (p *Plan) CommandLines() [][]string {
+	var cmdLines [][]string
+	for _, step := range p.Steps {
+		if step.CommandLine != nil {
+			cmdLines = append(cmdLines, step.CommandLine)
+		}
+	}
+	return cmdLines
+}
+
+// DryRun returns a copy of n that records the commands it would run in
+// the returned Plan instead of running them. Commands that only read
+// the export table, such as the exportfs -v run by ListExports and
+// Apply, are still run so that the plan reflects the live state.
+//
+// With a Backend, the plan holds the changes the backend would make,
+// such as the cache writes of a *Kernel. Backends that can't record
+// their changes fail to make them instead.
+func (n *Manager) DryRun() (*Manager, *Plan) {
+	d := *n
+	d.plan = &Plan{}
+	return &d, d.plan
+}
+
+// run runs cmdLine, a command that changes the export table, unless n
+// is a dry-run manager, in which case it is only added to the plan.
+func (n *Manager) run(ctx context.Context, cmdLine []string) ([]byte, error) {
+	if n.plan != nil {
+		n.plan.Steps = append(n.plan.Steps, PlanStep{CommandLine: cmdLine, Fallback: escalateCommandLine(n.Privilege, cmdLine)})
+		return nil, nil
+	}
+	return n.commandRetrier(ctx, cmdLine, n.Command, n.Privilege, n.Logger)
+}
@@ -0,0 +1,88 @@
+package nfsmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_nfsManager_DryRun(t *testing.T) {
+	fake := newFakeExportfs(Export{"/srv/remove", []ClientExport{{Host("10.0.0.1"), nil}}})
+	n := NFSManager()
+	n.commandRetrier = fake.run
+
+	d, plan := n.DryRun()
+	if err := d.ExportFs("/srv/a", Host("10.0.0.1"), RW); err != nil {
+		t.Fatalf("Manager.ExportFs() error = %v", err)
+	}
+	if err := d.UnExportFs("/srv/b", Host("10.0.0.1")); err != nil {
+		t.Fatalf("Manager.UnExportFs() error = %v", err)
+	}
+	if _, err := d.Apply([]Export{{"/srv/c", []ClientExport{{Anonymous, nil}}}}); err != nil {
+		t.Fatalf("Manager.Apply() error = %v", err)
+	}
+
+	if len(fake.calls) != 0 {
+		t.Errorf("dry run ran %v", fake.calls)
+	}
+
+	want := []PlanStep{
+		{CommandLine: []string{"exportfs", "10.0.0.1:/srv/a", "-o", "rw"}, Fallback: []string{"sudo", "-n", "exportfs", "10.0.0.1:/srv/a", "-o", "rw"}},
+		{CommandLine: []string{"exportfs", "-u", "10.0.0.1:/srv/b"}, Fallback: []string{"sudo", "-n", "exportfs", "-u", "10.0.0.1:/srv/b"}},
+		{CommandLine: []string{"exportfs", "-u", "10.0.0.1:/srv/remove"}, Fallback: []string{"sudo", "-n", "exportfs", "-u", "10.0.0.1:/srv/remove"}},
+		{CommandLine: []string{"exportfs", "*:/srv/c"}, Fallback: []string{"sudo", "-n", "exportfs", "*:/srv/c"}},
+	}
+	if !reflect.DeepEqual(plan.Steps, want) {
+		t.Errorf("Plan.Steps = %v, want %v", plan.Steps, want)
+	}
+
+	wantString := `exportfs 10.0.0.1:/srv/a -o rw (on failure: sudo -n exportfs 10.0.0.1:/srv/a -o rw)
+exportfs -u 10.0.0.1:/srv/b (on failure: sudo -n exportfs -u 10.0.0.1:/srv/b)
+exportfs -u 10.0.0.1:/srv/remove (on failure: sudo -n exportfs -u 10.0.0.1:/srv/remove)
+exportfs *:/srv/c (on failure: sudo -n exportfs *:/srv/c)`
+	if got := plan.String(); got != wantString {
+		t.Errorf("Plan.String() = %q, want %q", got, wantString)
+	}
+
+	if n.plan != nil {
+		t.Errorf("DryRun() changed the original manager")
+	}
+}
+
+func Test_nfsManager_DryRun_persistent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nfsmanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	n := NFSManager()
+	n.ExportsDir = dir
+	n, err = n.Persistent("myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, plan := n.DryRun()
+	if err := d.ExportFs("/srv/a", Host("10.0.0.1"), RW); err != nil {
+		t.Fatalf("Manager.ExportFs() error = %v", err)
+	}
+	if err := d.ExportFs("/srv/b", Host("10.0.0.2")); err != nil {
+		t.Fatalf("Manager.ExportFs() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "myapp.exports")); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote the managed file")
+	}
+
+	wantCmdLines := [][]string{reloadCommandLine(), reloadCommandLine()}
+	if got := plan.CommandLines(); !reflect.DeepEqual(got, wantCmdLines) {
+		t.Errorf("Plan.CommandLines() = %v, want %v", got, wantCmdLines)
+	}
+	wantContent := "# Managed by nfsmanager for myapp. Do not edit.\n/srv/a 10.0.0.1(rw)\n/srv/b 10.0.0.2\n"
+	if last := plan.Steps[2]; last.File != filepath.Join(dir, "myapp.exports") || string(last.Content) != wantContent {
+		t.Errorf("Plan.Steps[2] = %v, want write of %q", last, wantContent)
+	}
+}
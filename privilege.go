@@ -0,0 +1,82 @@
+package nfsmanager
+
+// Privilege decides how a command that failed for lack of privileges is
+// retried with elevated privileges.
+type Privilege interface {
+	// Escalate returns cmdLine wrapped so that it runs with elevated
+	// privileges, or nil if it should not be retried.
+	Escalate(cmdLine []string) []string
+}
+
+type noEscalation struct{}
+
+func (noEscalation) Escalate(cmdLine []string) []string {
+	return nil
+}
+
+// NoEscalation never retries failed commands.
+var NoEscalation Privilege = noEscalation{}
+
+// AlreadyRoot is for processes that run as root. Commands are never
+// retried, since a permission error can't be fixed by escalating.
+var AlreadyRoot Privilege = noEscalation{}
+
+// Sudo retries commands with sudo. The zero value runs "sudo -n", which
+// fails rather than prompting for a password.
+type Sudo struct {
+	// Binary is the sudo binary to run. It defaults to "sudo".
+	Binary string
+	// Flags are passed to Binary before the command. They default to
+	// "-n" if Flags is nil.
+	Flags []string
+}
+
+func (s Sudo) Escalate(cmdLine []string) []string {
+	return wrapCommandLine(s.Binary, "sudo", s.Flags, []string{"-n"}, cmdLine)
+}
+
+// Doas retries commands with OpenBSD's doas. The zero value runs
+// "doas -n", which fails rather than prompting for a password.
+type Doas struct {
+	// Binary is the doas binary to run. It defaults to "doas".
+	Binary string
+	// Flags are passed to Binary before the command. They default to
+	// "-n" if Flags is nil.
+	Flags []string
+}
+
+func (d Doas) Escalate(cmdLine []string) []string {
+	return wrapCommandLine(d.Binary, "doas", d.Flags, []string{"-n"}, cmdLine)
+}
+
+// Pkexec retries commands with polkit's pkexec.
+type Pkexec struct {
+	// Binary is the pkexec binary to run. It defaults to "pkexec".
+	Binary string
+	// Flags are passed to Binary before the command.
+	Flags []string
+}
+
+func (p Pkexec) Escalate(cmdLine []string) []string {
+	return wrapCommandLine(p.Binary, "pkexec", p.Flags, nil, cmdLine)
+}
+
+func wrapCommandLine(binary string, defaultBinary string, flags []string, defaultFlags []string, cmdLine []string) []string {
+	if binary == "" {
+		binary = defaultBinary
+	}
+	if flags == nil {
+		flags = defaultFlags
+	}
+	wrapped := append([]string{binary}, flags...)
+	return append(wrapped, cmdLine...)
+}
+
+// escalateCommandLine returns cmdLine escalated with privilege, which
+// may be nil.
+func escalateCommandLine(privilege Privilege, cmdLine []string) []string {
+	if privilege == nil {
+		return nil
+	}
+	return privilege.Escalate(cmdLine)
+}
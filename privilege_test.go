@@ -0,0 +1,34 @@
+package nfsmanager
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPrivilege_Escalate(t *testing.T) {
+	cmdLine := []string{"exportfs", "-v"}
+	tests := []struct {
+		name      string
+		privilege Privilege
+		want      []string
+	}{
+		{"NoEscalation", NoEscalation, nil},
+		{"AlreadyRoot", AlreadyRoot, nil},
+		{"Sudo", Sudo{}, []string{"sudo", "-n", "exportfs", "-v"}},
+		{"Sudo with binary and flags", Sudo{Binary: "/usr/local/bin/sudo", Flags: []string{"-n", "-u", "nfs"}}, []string{"/usr/local/bin/sudo", "-n", "-u", "nfs", "exportfs", "-v"}},
+		{"Sudo without flags", Sudo{Flags: []string{}}, []string{"sudo", "exportfs", "-v"}},
+		{"Doas", Doas{}, []string{"doas", "-n", "exportfs", "-v"}},
+		{"Pkexec", Pkexec{}, []string{"pkexec", "exportfs", "-v"}},
+		{"Pkexec with flags", Pkexec{Flags: []string{"--disable-internal-agent"}}, []string{"pkexec", "--disable-internal-agent", "exportfs", "-v"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.privilege.Escalate(cmdLine); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Privilege.Escalate() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(cmdLine, []string{"exportfs", "-v"}) {
+				t.Errorf("Privilege.Escalate() modified its argument: %v", cmdLine)
+			}
+		})
+	}
+}
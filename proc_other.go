@@ -0,0 +1,14 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package nfsmanager
+
+import "os/exec"
+
+// setProcessGroup does nothing where process groups aren't available.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the started cmd.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,307 @@
+package nfsmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// InitSystem is the way a Server starts and stops the NFS service.
+type InitSystem int
+
+const (
+	// InitAuto uses systemd if the host was booted with it and init
+	// scripts otherwise.
+	InitAuto InitSystem = iota
+	// InitSystemd manages the service with systemctl.
+	InitSystemd
+	// InitScript manages the service with its script in /etc/init.d.
+	InitScript
+)
+
+func (s InitSystem) String() string {
+	switch s {
+	case InitAuto:
+		return "auto"
+	case InitSystemd:
+		return "systemd"
+	case InitScript:
+		return "init script"
+	default:
+		return fmt.Sprintf("InitSystem(%d)", int(s))
+	}
+}
+
+// DefaultServices are the names the NFS server service goes by: the
+// first on Red Hat and SUSE, the second on Debian and Ubuntu.
+var DefaultServices = []string{"nfs-server", "nfs-kernel-server"}
+
+// systemdUnitDirs are searched for the service's unit file.
+var systemdUnitDirs = []string{"/etc/systemd/system", "/run/systemd/system", "/usr/lib/systemd/system", "/lib/systemd/system"}
+
+// exitNotRunning is the exit code of both `systemctl is-active` and
+// LSB init script status actions for a service that isn't running.
+const exitNotRunning = 3
+
+// ProtocolVersion is an NFS protocol version nfsd may serve.
+type ProtocolVersion struct {
+	// Version is the version number, e.g. "3" or "4.2".
+	Version string
+	// Enabled tells whether nfsd serves the version.
+	Enabled bool
+}
+
+// ServerStatus describes the state of the NFS server.
+type ServerStatus struct {
+	// Service is the name of the service that was checked.
+	Service string
+	// Active tells whether the service is running.
+	Active bool
+	// Threads is the number of nfsd threads, or 0 if nfsd isn't
+	// running.
+	Threads int
+	// Versions are the protocol versions listed in
+	// /proc/fs/nfsd/versions, or nil if nfsd isn't running.
+	Versions []ProtocolVersion
+}
+
+// Server manages the NFS server service. Without nfsd running, the
+// export table can't be changed and exportfs fails with
+// CauseNFSDNotRunning.
+type Server struct {
+	// Command creates the commands Server runs. It defaults to
+	// exec.Command.
+	Command        execCommander
+	commandRetrier commandRetrierWithPrivilege
+
+	// Privilege is used to retry commands that fail for lack of
+	// privileges. It defaults to Sudo{}, i.e. "sudo -n".
+	Privilege Privilege
+
+	// Logger receives a record for every command run. It defaults to
+	// discarding them.
+	Logger Logger
+
+	// Init selects how the service is managed. It defaults to InitAuto.
+	Init InitSystem
+
+	// Services are the names to look for the service under, in order.
+	// The first one with a unit file or init script is used. It defaults
+	// to DefaultServices.
+	Services []string
+
+	// Root is prepended to the files Server inspects to find the init
+	// system, the service and the state of nfsd. It defaults to "/".
+	Root string
+}
+
+// NFSServer returns a Server for the local NFS server.
+func NFSServer() *Server {
+	return &Server{
+		Command:        exec.Command,
+		commandRetrier: runAndEscalateOnPermissionError,
+		Privilege:      Sudo{},
+		Logger:         NopLogger,
+	}
+}
+
+// Server returns a Server that runs commands the way n does.
+func (n *Manager) Server() *Server {
+	s := NFSServer()
+	s.Command = n.Command
+	s.commandRetrier = n.commandRetrier
+	s.Privilege = n.Privilege
+	s.Logger = n.Logger
+	return s
+}
+
+func (s *Server) initSystem() InitSystem {
+	if s.Init != InitAuto {
+		return s.Init
+	}
+	if info, err := os.Stat(rootedPath(s.Root, "/run/systemd/system")); err == nil && info.IsDir() {
+		return InitSystemd
+	}
+	return InitScript
+}
+
+// service returns the first of Services that init has a unit file or
+// init script for.
+func (s *Server) service(init InitSystem) (string, error) {
+	services := s.Services
+	if len(services) == 0 {
+		services = DefaultServices
+	}
+	for _, name := range services {
+		var candidates []string
+		if init == InitSystemd {
+			for _, dir := range systemdUnitDirs {
+				candidates = append(candidates, dir+"/"+name+".service")
+			}
+		} else {
+			candidates = []string{"/etc/init.d/" + name}
+		}
+		for _, path := range candidates {
+			if _, err := os.Stat(rootedPath(s.Root, path)); err == nil {
+				return name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no %s service found for any of %s", init, strings.Join(services, ", "))
+}
+
+func (s *Server) commandLine(action string) ([]string, string, error) {
+	init := s.initSystem()
+	service, err := s.service(init)
+	if err != nil {
+		return nil, "", err
+	}
+	if init == InitSystemd {
+		if action == "status" {
+			return []string{"systemctl", "is-active", "--quiet", service}, service, nil
+		}
+		return []string{"systemctl", action, service}, service, nil
+	}
+	return []string{"/etc/init.d/" + service, action}, service, nil
+}
+
+func (s *Server) do(ctx context.Context, action string) error {
+	cmdLine, _, err := s.commandLine(action)
+	if err != nil {
+		return err
+	}
+	_, err = s.commandRetrier(ctx, cmdLine, s.Command, s.Privilege, s.Logger)
+	return err
+}
+
+// Start starts the NFS server.
+func (s *Server) Start() error {
+	return s.StartContext(context.Background())
+}
+
+// StartContext is like Start, but the command is killed if ctx is done
+// before it completes.
+func (s *Server) StartContext(ctx context.Context) error {
+	return s.do(ctx, "start")
+}
+
+// Stop stops the NFS server.
+func (s *Server) Stop() error {
+	return s.StopContext(context.Background())
+}
+
+// StopContext is like Stop, but the command is killed if ctx is done
+// before it completes.
+func (s *Server) StopContext(ctx context.Context) error {
+	return s.do(ctx, "stop")
+}
+
+// Restart stops and starts the NFS server, e.g. to change the number
+// of nfsd threads.
+func (s *Server) Restart() error {
+	return s.RestartContext(context.Background())
+}
+
+// RestartContext is like Restart, but the command is killed if ctx is
+// done before it completes.
+func (s *Server) RestartContext(ctx context.Context) error {
+	return s.do(ctx, "restart")
+}
+
+// Reload makes the running NFS server reread its exports.
+func (s *Server) Reload() error {
+	return s.ReloadContext(context.Background())
+}
+
+// ReloadContext is like Reload, but the command is killed if ctx is done
+// before it completes.
+func (s *Server) ReloadContext(ctx context.Context) error {
+	return s.do(ctx, "reload")
+}
+
+// Active reports whether the NFS server service is running.
+func (s *Server) Active() (bool, error) {
+	return s.ActiveContext(context.Background())
+}
+
+// ActiveContext is like Active, but the command is killed if ctx is done
+// before it completes.
+func (s *Server) ActiveContext(ctx context.Context) (bool, error) {
+	status, err := s.StatusContext(ctx)
+	return status.Active, err
+}
+
+// Status reports whether the NFS server service is running, and if so
+// how many threads nfsd has and which protocol versions it serves.
+func (s *Server) Status() (ServerStatus, error) {
+	return s.StatusContext(context.Background())
+}
+
+// StatusContext is like Status, but the command is killed if ctx is done
+// before it completes.
+func (s *Server) StatusContext(ctx context.Context) (ServerStatus, error) {
+	cmdLine, service, err := s.commandLine("status")
+	if err != nil {
+		return ServerStatus{}, err
+	}
+	status := ServerStatus{Service: service}
+
+	_, err = s.commandRetrier(ctx, cmdLine, s.Command, s.Privilege, s.Logger)
+	var exportErr *ExportError
+	switch {
+	case err == nil:
+		status.Active = true
+	case errors.As(err, &exportErr) && exportErr.ExitCode == exitNotRunning:
+		return status, nil
+	default:
+		return status, err
+	}
+
+	if status.Threads, err = readNFSDThreads(s.Root); err != nil {
+		return status, err
+	}
+	if status.Versions, err = readNFSDVersions(s.Root); err != nil {
+		return status, err
+	}
+	return status, nil
+}
+
+// readNFSDThreads returns the number of nfsd threads, or 0 if the nfsd
+// filesystem isn't mounted.
+func readNFSDThreads(root string) (int, error) {
+	content, err := ioutil.ReadFile(rootedPath(root, "/proc/fs/nfsd/threads"))
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	threads, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0, fmt.Errorf("/proc/fs/nfsd/threads: %w", err)
+	}
+	return threads, nil
+}
+
+// readNFSDVersions parses /proc/fs/nfsd/versions, e.g. "-2 +3 +4 +4.1
+// +4.2", or returns nil if the nfsd filesystem isn't mounted.
+func readNFSDVersions(root string) ([]ProtocolVersion, error) {
+	content, err := ioutil.ReadFile(rootedPath(root, "/proc/fs/nfsd/versions"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var versions []ProtocolVersion
+	for _, field := range strings.Fields(string(content)) {
+		if len(field) < 2 || (field[0] != '+' && field[0] != '-') {
+			return nil, fmt.Errorf("/proc/fs/nfsd/versions: invalid version %q", field)
+		}
+		versions = append(versions, ProtocolVersion{Version: field[1:], Enabled: field[0] == '+'})
+	}
+	return versions, nil
+}
@@ -0,0 +1,133 @@
+package nfsmanager
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func serverTestManager(t *testing.T, root string, calls *[][]string, err error) *Server {
+	s := NFSServer()
+	s.Root = root
+	s.commandRetrier = func(ctx context.Context, cmdLine []string, command execCommander, privilege Privilege, logger Logger) ([]byte, error) {
+		*calls = append(*calls, cmdLine)
+		return nil, err
+	}
+	return s
+}
+
+func TestServer_systemd(t *testing.T) {
+	root, err := ioutil.TempDir("", "nfsmanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	writeTestFile(t, root, "run/systemd/system/.keep", "")
+	writeTestFile(t, root, "lib/systemd/system/nfs-kernel-server.service", "")
+	writeTestFile(t, root, "proc/fs/nfsd/threads", "8\n")
+	writeTestFile(t, root, "proc/fs/nfsd/versions", "-2 +3 +4 +4.1 -4.2\n")
+
+	var calls [][]string
+	s := serverTestManager(t, root, &calls, nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("Server.Start() error = %v", err)
+	}
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Server.Reload() error = %v", err)
+	}
+	status, err := s.Status()
+	if err != nil {
+		t.Fatalf("Server.Status() error = %v", err)
+	}
+	if err := s.Stop(); err != nil {
+		t.Fatalf("Server.Stop() error = %v", err)
+	}
+
+	wantCalls := [][]string{
+		{"systemctl", "start", "nfs-kernel-server"},
+		{"systemctl", "reload", "nfs-kernel-server"},
+		{"systemctl", "is-active", "--quiet", "nfs-kernel-server"},
+		{"systemctl", "stop", "nfs-kernel-server"},
+	}
+	if !reflect.DeepEqual(calls, wantCalls) {
+		t.Errorf("commands = %v, want %v", calls, wantCalls)
+	}
+	wantStatus := ServerStatus{
+		Service: "nfs-kernel-server",
+		Active:  true,
+		Threads: 8,
+		Versions: []ProtocolVersion{
+			{"2", false}, {"3", true}, {"4", true}, {"4.1", true}, {"4.2", false},
+		},
+	}
+	if !reflect.DeepEqual(status, wantStatus) {
+		t.Errorf("Server.Status() = %+v, want %+v", status, wantStatus)
+	}
+}
+
+func TestServer_escalate(t *testing.T) {
+	root, err := ioutil.TempDir("", "nfsmanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	writeTestFile(t, root, "run/systemd/system/.keep", "")
+	writeTestFile(t, root, "lib/systemd/system/nfs-server.service", "")
+
+	var calls [][]string
+	s := NFSServer()
+	s.Root = root
+	s.Command = func(name string, arg ...string) *exec.Cmd {
+		calls = append(calls, append([]string{name}, arg...))
+		if name == "sudo" {
+			return exec.Command("true")
+		}
+		return exec.Command("sh", "-c", "echo 'Failed to start nfs-server.service: Interactive authentication required.' >&2; exit 1")
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("Server.Start() error = %v", err)
+	}
+	wantCalls := [][]string{
+		{"systemctl", "start", "nfs-server"},
+		{"sudo", "-n", "systemctl", "start", "nfs-server"},
+	}
+	if !reflect.DeepEqual(calls, wantCalls) {
+		t.Errorf("commands = %v, want %v", calls, wantCalls)
+	}
+}
+
+func TestServer_initScript(t *testing.T) {
+	root, err := ioutil.TempDir("", "nfsmanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	writeTestFile(t, root, "etc/init.d/nfs-server", "")
+
+	var calls [][]string
+	s := serverTestManager(t, root, &calls, &ExportError{ExitCode: 3, Err: fmt.Errorf("exit status 3")})
+	status, err := s.Status()
+	if err != nil {
+		t.Fatalf("Server.Status() error = %v", err)
+	}
+	if want := (ServerStatus{Service: "nfs-server"}); !reflect.DeepEqual(status, want) {
+		t.Errorf("Server.Status() = %+v, want %+v", status, want)
+	}
+	if want := [][]string{{"/etc/init.d/nfs-server", "status"}}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("commands = %v, want %v", calls, want)
+	}
+
+	s = serverTestManager(t, root, &calls, &ExportError{ExitCode: 4, Err: fmt.Errorf("exit status 4")})
+	if _, err := s.Active(); err == nil {
+		t.Errorf("Server.Active() error = nil, want error for exit status 4")
+	}
+
+	s.Services = []string{"nfs"}
+	if err := s.Start(); err == nil {
+		t.Errorf("Server.Start() of a missing service error = nil, want error")
+	}
+}
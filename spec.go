@@ -0,0 +1,313 @@
+package nfsmanager
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Spec is a declarative description of exports, loaded from YAML or
+// JSON such as:
+//
+//	owner: myapp
+//	exports:
+//	  - path: /srv/data
+//	    clients:
+//	      - client: 10.0.0.0/8
+//	        options: [rw, no_root_squash]
+//	      - client: "*"
+//	        options: ro,all_squash
+//
+// Options may be given as a list or as a comma separated string, and
+// client specifications as accepted by ParseClient.
+type Spec struct {
+	// Owner labels the exports as belonging to an application. See
+	// ApplySpec.
+	Owner   string
+	Exports []Export
+}
+
+// SpecError lists the problems found in a spec.
+type SpecError struct {
+	// File is the name the spec was loaded from.
+	File     string
+	Problems []SpecProblem
+}
+
+// SpecProblem is a single problem found in a spec.
+type SpecProblem struct {
+	Line, Column int
+	// Field is the path of the offending field, e.g.
+	// "exports[0].clients[1].options".
+	Field   string
+	Message string
+}
+
+func (p SpecProblem) String() string {
+	if p.Field == "" {
+		return fmt.Sprintf("%d:%d: %s", p.Line, p.Column, p.Message)
+	}
+	return fmt.Sprintf("%d:%d: %s: %s", p.Line, p.Column, p.Field, p.Message)
+}
+
+func (e *SpecError) Error() string {
+	var lines []string
+	for _, p := range e.Problems {
+		lines = append(lines, e.File+":"+p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// LoadSpec reads and parses the YAML or JSON spec at path.
+func LoadSpec(path string) (*Spec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSpec(path, data)
+}
+
+// ParseSpec parses a YAML or JSON spec. name is used in error messages.
+// All problems found, including invalid clients and options, are
+// returned together in a *SpecError.
+func ParseSpec(name string, data []byte) (*Spec, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	p := &specParser{err: &SpecError{File: name}}
+	spec := &Spec{}
+	if len(doc.Content) == 0 {
+		p.problem(&doc, "", "empty spec")
+	} else {
+		p.parseSpec(doc.Content[0], spec)
+	}
+	if len(p.err.Problems) > 0 {
+		return nil, p.err
+	}
+	return spec, nil
+}
+
+type specParser struct {
+	err *SpecError
+}
+
+func (p *specParser) problem(node *yaml.Node, field string, format string, args ...interface{}) {
+	p.err.Problems = append(p.err.Problems, SpecProblem{
+		Line:    node.Line,
+		Column:  node.Column,
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// fields returns the values of a mapping node by key, reporting keys not
+// in known and missing keys in required.
+func (p *specParser) fields(node *yaml.Node, field string, known []string, required ...string) map[string]*yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		p.problem(node, field, "expected a mapping")
+		return nil
+	}
+	values := make(map[string]*yaml.Node)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		isKnown := false
+		for _, k := range known {
+			isKnown = isKnown || k == key.Value
+		}
+		if !isKnown {
+			p.problem(key, join(field, key.Value), "unknown field")
+			continue
+		}
+		if _, ok := values[key.Value]; ok {
+			p.problem(key, join(field, key.Value), "given more than once")
+		}
+		values[key.Value] = value
+	}
+	for _, k := range required {
+		if _, ok := values[k]; !ok {
+			p.problem(node, join(field, k), "missing")
+		}
+	}
+	return values
+}
+
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+func (p *specParser) scalar(node *yaml.Node, field string) (string, bool) {
+	if node.Kind != yaml.ScalarNode {
+		p.problem(node, field, "expected a string")
+		return "", false
+	}
+	return node.Value, true
+}
+
+func (p *specParser) sequence(node *yaml.Node, field string) []*yaml.Node {
+	if node.Kind != yaml.SequenceNode {
+		p.problem(node, field, "expected a list")
+		return nil
+	}
+	return node.Content
+}
+
+func (p *specParser) parseSpec(node *yaml.Node, spec *Spec) {
+	fields := p.fields(node, "", []string{"owner", "exports"}, "exports")
+	if owner, ok := fields["owner"]; ok {
+		spec.Owner, _ = p.scalar(owner, "owner")
+	}
+	exports, ok := fields["exports"]
+	if !ok {
+		return
+	}
+
+	paths := make(map[string]bool)
+	for i, node := range p.sequence(exports, "exports") {
+		field := fmt.Sprintf("exports[%d]", i)
+		export, ok := p.parseExport(node, field)
+		if !ok {
+			continue
+		}
+		if paths[export.Path] {
+			p.problem(node, join(field, "path"), "%s is given more than once", export.Path)
+			continue
+		}
+		paths[export.Path] = true
+		spec.Exports = append(spec.Exports, export)
+	}
+}
+
+func (p *specParser) parseExport(node *yaml.Node, field string) (Export, bool) {
+	fields := p.fields(node, field, []string{"path", "clients"}, "path", "clients")
+	if fields == nil {
+		return Export{}, false
+	}
+	export := Export{}
+	ok := true
+
+	if node, found := fields["path"]; found {
+		path, isScalar := p.scalar(node, join(field, "path"))
+		if isScalar && !filepath.IsAbs(path) {
+			p.problem(node, join(field, "path"), "path must be absolute")
+			isScalar = false
+		}
+		export.Path, ok = path, isScalar
+	}
+
+	if node, found := fields["clients"]; found {
+		clients := make(map[Client]bool)
+		for i, node := range p.sequence(node, join(field, "clients")) {
+			clientField := fmt.Sprintf("%s.clients[%d]", field, i)
+			client, clientOK := p.parseClient(node, clientField)
+			if !clientOK {
+				ok = false
+				continue
+			}
+			if clients[client.Client] {
+				p.problem(node, clientField, "client %s is given more than once", client.Client)
+				ok = false
+				continue
+			}
+			clients[client.Client] = true
+			export.Clients = append(export.Clients, client)
+		}
+	}
+	return export, ok
+}
+
+func (p *specParser) parseClient(node *yaml.Node, field string) (ClientExport, bool) {
+	fields := p.fields(node, field, []string{"client", "options"}, "client")
+	if fields == nil {
+		return ClientExport{}, false
+	}
+	ok := true
+
+	var client Client
+	if node, found := fields["client"]; found {
+		spec, isScalar := p.scalar(node, join(field, "client"))
+		if isScalar {
+			var err error
+			if client, err = ParseClient(spec); err != nil {
+				p.problem(node, join(field, "client"), "%v", err)
+				ok = false
+			}
+		} else {
+			ok = false
+		}
+	}
+
+	var options []Option
+	if node, found := fields["options"]; found {
+		optionsField := join(field, "options")
+		var values []*yaml.Node
+		if node.Kind == yaml.SequenceNode {
+			values = node.Content
+		} else {
+			values = []*yaml.Node{node}
+		}
+		for _, value := range values {
+			s, isScalar := p.scalar(value, optionsField)
+			if !isScalar {
+				ok = false
+				continue
+			}
+			parsed, err := ParseOptions(s)
+			if err != nil {
+				p.problem(value, optionsField, "%v", err)
+				ok = false
+				continue
+			}
+			options = append(options, parsed...)
+		}
+		if ok {
+			if err := ValidateOptions(options...); err != nil {
+				p.problem(node, optionsField, "%v", err)
+				ok = false
+			}
+		}
+	}
+	return ClientExport{Client: client, Options: options}, ok
+}
+
+// ExportsFile returns the spec's exports as an exports(5) document.
+func (s *Spec) ExportsFile() *ExportsFile {
+	f := &ExportsFile{}
+	if s.Owner != "" {
+		f.Lines = append(f.Lines, &ExportsLine{Comment: "# Owner: " + s.Owner})
+	}
+	for _, export := range s.Exports {
+		f.Set(export)
+	}
+	return f
+}
+
+// ApplySpec is like Apply with spec's exports. If the spec has an
+// owner, they are applied to that owner's managed file as with
+// Persistent, unless n is persistent already, in which case the owners
+// must match.
+func (n *Manager) ApplySpec(spec *Spec) (ChangeReport, error) {
+	return n.ApplySpecContext(context.Background(), spec)
+}
+
+// ApplySpecContext is like ApplySpec, but stops once ctx is done.
+func (n *Manager) ApplySpecContext(ctx context.Context, spec *Spec) (ChangeReport, error) {
+	if spec.Owner != "" && n.Owner != spec.Owner {
+		if n.Owner != "" {
+			return ChangeReport{}, fmt.Errorf("spec is owned by %s, not %s", spec.Owner, n.Owner)
+		}
+		var err error
+		if n, err = n.Persistent(spec.Owner); err != nil {
+			return ChangeReport{}, err
+		}
+	}
+	return n.ApplyContext(ctx, spec.Exports)
+}
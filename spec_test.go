@@ -0,0 +1,122 @@
+package nfsmanager
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSpec(t *testing.T) {
+	yamlSpec := `owner: myapp
+exports:
+  - path: /srv/data
+    clients:
+      - client: 10.0.0.0/8
+        options: [rw, no_root_squash]
+      - client: "*"
+        options: ro,all_squash
+  - path: /srv/with space
+    clients:
+      - client: "@admins"
+`
+	jsonSpec := `{"owner": "myapp", "exports": [
+  {"path": "/srv/data", "clients": [
+    {"client": "10.0.0.0/8", "options": ["rw", "no_root_squash"]},
+    {"client": "*", "options": "ro,all_squash"}]},
+  {"path": "/srv/with space", "clients": [{"client": "@admins"}]}]}`
+
+	want := &Spec{Owner: "myapp", Exports: []Export{
+		{"/srv/data", []ClientExport{
+			{Network("10.0.0.0/8"), []Option{RW, NoRootSquash}},
+			{Anonymous, []Option{RO, AllSquash}},
+		}},
+		{"/srv/with space", []ClientExport{{Netgroup("admins"), nil}}},
+	}}
+	for name, data := range map[string]string{"spec.yaml": yamlSpec, "spec.json": jsonSpec} {
+		got, err := ParseSpec(name, []byte(data))
+		if err != nil {
+			t.Fatalf("ParseSpec(%s) error = %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ParseSpec(%s) = %v, want %v", name, got, want)
+		}
+	}
+
+	wantFile := "# Owner: myapp\n/srv/data 10.0.0.0/8(rw,no_root_squash) *(ro,all_squash)\n/srv/with\\040space @admins\n"
+	if got := string(want.ExportsFile().Bytes()); got != wantFile {
+		t.Errorf("Spec.ExportsFile() = %q, want %q", got, wantFile)
+	}
+}
+
+func TestParseSpec_errors(t *testing.T) {
+	data := `exports:
+  - path: srv/relative
+    clients:
+      - client: 10.0.0.1
+        options: [rw, ro]
+      - client: bad host
+      - client: 10.0.0.1
+        option: rw
+  - path: /srv/b
+`
+	_, err := ParseSpec("spec.yaml", []byte(data))
+	var specErr *SpecError
+	if !errors.As(err, &specErr) {
+		t.Fatalf("ParseSpec() error = %v, want *SpecError", err)
+	}
+	want := []string{
+		"2:11: exports[0].path: path must be absolute",
+		"5:18: exports[0].clients[0].options: invalid options: ro contradicts rw",
+		"6:17: exports[0].clients[1].client: client \"bad host\" contains invalid characters",
+		"8:9: exports[0].clients[2].option: unknown field",
+		"9:5: exports[1].clients: missing",
+	}
+	var got []string
+	for _, p := range specErr.Problems {
+		got = append(got, p.String())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseSpec() problems = %q, want %q", got, want)
+	}
+	if !strings.HasPrefix(err.Error(), "spec.yaml:2:11: ") {
+		t.Errorf("SpecError.Error() = %q, want it prefixed with the file and line", err)
+	}
+
+	if _, err := ParseSpec("spec.yaml", []byte("exports: [\n")); err == nil {
+		t.Errorf("ParseSpec() of malformed YAML error = nil, want error")
+	}
+}
+
+func TestManager_ApplySpec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nfsmanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	reloads := 0
+	n := persistentTestManager(t, dir, &reloads, nil)
+	n.Owner = ""
+
+	spec := &Spec{Owner: "myapp", Exports: []Export{{"/srv/a", []ClientExport{{Host("10.0.0.1"), []Option{RW}}}}}}
+	report, err := n.ApplySpec(spec)
+	if err != nil {
+		t.Fatalf("Manager.ApplySpec() error = %v", err)
+	}
+	if len(report.Changes) != 1 {
+		t.Errorf("Manager.ApplySpec() = %v, want one change", report.Changes)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(dir, "myapp.exports"))
+	if err != nil || !strings.Contains(string(got), "/srv/a 10.0.0.1(rw)") {
+		t.Errorf("managed file = %q, %v, want the spec's export", got, err)
+	}
+
+	n.Owner = "other"
+	if _, err := n.ApplySpec(spec); err == nil {
+		t.Errorf("Manager.ApplySpec() with another owner error = nil, want error")
+	}
+}
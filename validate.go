@@ -0,0 +1,210 @@
+package nfsmanager
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// OptionsError lists the problems ValidateOptions found in a set of
+// options.
+type OptionsError struct {
+	Problems []string
+}
+
+func (e *OptionsError) Error() string {
+	return fmt.Sprintf("invalid options: %s", strings.Join(e.Problems, "; "))
+}
+
+// meaninglessCombinations lists pairs of options where the first has no
+// effect when the second is given.
+var meaninglessCombinations = [][2]string{
+	{"no_wdelay", "async"},
+	{"no_root_squash", "all_squash"},
+}
+
+// perFlavorOptions are the options a Sec option limits to its flavors,
+// as nfs-utils keeps them per flavor in secinfo. All other options apply
+// to the whole export wherever they are given.
+var perFlavorOptions = map[string]bool{
+	"ro": true, "rw": true,
+	"root_squash": true, "no_root_squash": true,
+	"all_squash": true, "no_all_squash": true,
+	"secure": true, "insecure": true,
+}
+
+var validSecFlavors = map[string]bool{"sys": true, "krb5": true, "krb5i": true, "krb5p": true}
+var validXprtSecPolicies = map[string]bool{"none": true, "tls": true, "mtls": true}
+
+// ValidateOptions checks options for contradictions, such as RW with
+// RO, duplicates, combinations where an option has no effect, such as
+// NoWDelay with ASync, and invalid values, such as a malformed UUID in
+// FsID or Refer without locations. All problems found are returned
+// together in an *OptionsError.
+//
+// A Sec option starts a new section in which the per-flavor options,
+// RO, RW and the squash and port options, only apply to the flavors it
+// lists, so e.g. RW after Sec("krb5p") doesn't contradict RO after
+// Sec("sys"). Other options apply to the whole export, so FsID given
+// before and after a Sec is still given twice.
+func ValidateOptions(options ...Option) error {
+	var problems []string
+
+	sections := secSections(options)
+	seen := make(map[string]Option)
+	present := make(map[string]bool)
+	for i, section := range sections {
+		for _, opt := range section {
+			canonical := opt.canonical()
+			present[scopedOption(canonical.optionString, i)] = true
+			if canonical.optionString == "sec" {
+				if problem := validateOptionValue(opt); problem != "" {
+					problems = append(problems, problem)
+				}
+				continue
+			}
+			key := canonical.optionString
+			if group, ok := optionGroup(key); ok {
+				key = group[0]
+			}
+			key = scopedOption(key, i)
+
+			if prev, ok := seen[key]; ok {
+				if prev.canonical().string() == canonical.string() {
+					problems = append(problems, fmt.Sprintf("%s given more than once", opt.string()))
+				} else {
+					problems = append(problems, fmt.Sprintf("%s contradicts %s", opt.string(), prev.string()))
+				}
+				continue
+			}
+			seen[key] = opt
+
+			if problem := validateOptionValue(opt); problem != "" {
+				problems = append(problems, problem)
+			}
+		}
+	}
+
+	for _, pair := range meaninglessCombinations {
+		for i := range sections {
+			if present[scopedOption(pair[0], i)] && present[scopedOption(pair[1], i)] {
+				problems = append(problems, fmt.Sprintf("%s has no effect with %s", pair[0], pair[1]))
+				break
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &OptionsError{Problems: problems}
+	}
+	return nil
+}
+
+// scopedOption returns the key name is tracked by in sec section i:
+// per-flavor options are tracked per section, all others across the
+// whole export.
+func scopedOption(name string, i int) string {
+	if perFlavorOptions[name] {
+		return fmt.Sprintf("%d:%s", i, name)
+	}
+	return name
+}
+
+// secSections splits options into sections starting at each Sec
+// option.
+func secSections(options []Option) [][]Option {
+	sections := [][]Option{nil}
+	for _, opt := range options {
+		if opt.optionString == "sec" {
+			sections = append(sections, nil)
+		}
+		sections[len(sections)-1] = append(sections[len(sections)-1], opt)
+	}
+	return sections
+}
+
+func validateOptionValue(opt Option) string {
+	values := nonEmpty(opt.extra)
+	switch opt.optionString {
+	case "fsid":
+		if len(values) != 1 || !validFsID(values[0]) {
+			return fmt.Sprintf("%s: fsid must be root, a number or a UUID", opt.string())
+		}
+	case "refer", "replicas":
+		if len(values) == 0 {
+			return fmt.Sprintf("%s has no locations", opt.optionString)
+		}
+		for _, location := range values {
+			if !strings.Contains(location, "@") {
+				return fmt.Sprintf("%s: location %q is not of the form path@host", opt.optionString, location)
+			}
+		}
+	case "sec":
+		if len(values) == 0 {
+			return "sec has no flavors"
+		}
+		for _, flavor := range values {
+			if !validSecFlavors[flavor] {
+				return fmt.Sprintf("sec: unknown flavor %q", flavor)
+			}
+		}
+	case "xprtsec":
+		if len(values) == 0 {
+			return "xprtsec has no policies"
+		}
+		for _, policy := range values {
+			if !validXprtSecPolicies[policy] {
+				return fmt.Sprintf("xprtsec: unknown policy %q", policy)
+			}
+		}
+	case "mountpoint", "mp":
+		if len(values) > 0 && !filepath.IsAbs(values[0]) {
+			return fmt.Sprintf("%s: path must be absolute", opt.string())
+		}
+	}
+	return ""
+}
+
+// validFsID reports whether id is "root", a number, or a UUID: 32 hex
+// digits with arbitrary punctuation.
+func validFsID(id string) bool {
+	if id == "root" {
+		return true
+	}
+	if isNumber(id) {
+		return true
+	}
+
+	digits := 0
+	for _, c := range id {
+		switch {
+		case c >= '0' && c <= '9', c >= 'a' && c <= 'f', c >= 'A' && c <= 'F':
+			digits++
+		case c >= 'g' && c <= 'z', c >= 'G' && c <= 'Z':
+			return false
+		}
+	}
+	return digits == 32
+}
+
+func isNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func nonEmpty(values []string) []string {
+	var result []string
+	for _, v := range values {
+		if v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,85 @@
+package nfsmanager
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidateOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+		want    []string
+	}{
+		{"No options", nil, nil},
+		{"Valid options", []Option{RW, Sync, NoSubtreeCheck, FsIDNumber(7), AnonUID(1000)}, nil},
+		{"RW and RO", []Option{RW, RO}, []string{"ro contradicts rw"}},
+		{"Sync and ASync", []Option{Sync, ASync}, []string{"async contradicts sync"}},
+		{"RootSquash and NoRootSquash", []Option{RootSquash, NoRootSquash}, []string{"no_root_squash contradicts root_squash"}},
+		{"Synonyms contradict", []Option{NoAuthNLM, SecureLocks}, []string{"secure_locks contradicts no_auth_nlm"}},
+		{"Different values contradict", []Option{FsIDNumber(1), FsIDNumber(2)}, []string{"fsid=2 contradicts fsid=1"}},
+		{"Duplicate", []Option{RW, RW}, []string{"rw given more than once"}},
+		{"Duplicate synonym", []Option{MountPoint("/mnt"), MP("/mnt")}, []string{"mp=/mnt given more than once"}},
+		{"NoWDelay with ASync", []Option{NoWDelay, ASync}, []string{"no_wdelay has no effect with async"}},
+		{"NoRootSquash with AllSquash", []Option{AllSquash, NoRootSquash}, []string{"no_root_squash has no effect with all_squash"}},
+		{"FsID root", []Option{FsIDRoot}, nil},
+		{"FsID UUID", []Option{FsID("6ba7b810-9dad-11d1-80b4-00c04fd430c8")}, nil},
+		{"FsID UUID with other punctuation", []Option{FsID("6ba7b810:9dad11d1:80b400c0:4fd430c8")}, nil},
+		{"FsID malformed UUID", []Option{FsID("6ba7b810-9dad-11d1-80b4")}, []string{"fsid=6ba7b810-9dad-11d1-80b4: fsid must be root, a number or a UUID"}},
+		{"FsID not hex", []Option{FsID("the-fs-id")}, []string{"fsid=the-fs-id: fsid must be root, a number or a UUID"}},
+		{"FsID empty", []Option{FsID("")}, []string{"fsid: fsid must be root, a number or a UUID"}},
+		{"Refer without locations", []Option{Refer()}, []string{"refer has no locations"}},
+		{"Replicas with empty locations", []Option{Replicas("", "")}, []string{"replicas has no locations"}},
+		{"Refer without host", []Option{Refer("/a")}, []string{`refer: location "/a" is not of the form path@host`}},
+		{"Sec without flavors", []Option{Sec()}, []string{"sec has no flavors"}},
+		{"Sec with unknown flavor", []Option{Sec("krb6")}, []string{`sec: unknown flavor "krb6"`}},
+		{"XprtSec with unknown policy", []Option{XprtSec("ssl")}, []string{`xprtsec: unknown policy "ssl"`}},
+		{"Relative mountpoint", []Option{MP("mnt")}, []string{"mp=mnt: path must be absolute"}},
+		{"Per flavor options", []Option{Sec("krb5p"), RW, Sec("sys"), RO}, nil},
+		{"Contradiction within flavor", []Option{Sec("sys"), RW, RO}, []string{"ro contradicts rw"}},
+		{"FsID across flavors", []Option{FsID("1"), Sec("sys"), FsID("2")}, []string{"fsid=2 contradicts fsid=1"}},
+		{"AnonUID across flavors", []Option{Sec("krb5p"), AnonUID(1), Sec("sys"), AnonUID(1)}, []string{"anonuid=1 given more than once"}},
+		{"Flag across flavors", []Option{Sec("krb5p"), ASync, Sec("sys"), Sync}, []string{"sync contradicts async"}},
+		{"Several problems", []Option{RW, RO, Refer(), NoWDelay, ASync}, []string{"ro contradicts rw", "refer has no locations", "no_wdelay has no effect with async"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateOptions(tt.options...)
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("ValidateOptions() error = %v, want nil", err)
+				}
+				return
+			}
+			var optionsErr *OptionsError
+			if !errors.As(err, &optionsErr) {
+				t.Fatalf("ValidateOptions() error = %v, want *OptionsError", err)
+			}
+			if !reflect.DeepEqual(optionsErr.Problems, tt.want) {
+				t.Errorf("ValidateOptions() problems = %q, want %q", optionsErr.Problems, tt.want)
+			}
+		})
+	}
+}
+
+func Test_nfsManager_ExportFs_validates(t *testing.T) {
+	n := NFSManager()
+	fake := newFakeExportfs()
+	n.commandRetrier = fake.run
+
+	err := n.ExportFs("/foo/bar", Host("the.client"), RW, RO)
+	var optionsErr *OptionsError
+	if !errors.As(err, &optionsErr) {
+		t.Errorf("Manager.ExportFs() error = %v, want *OptionsError", err)
+	}
+
+	_, err = n.Apply([]Export{{"/foo/bar", []ClientExport{{Host("the.client"), []Option{Refer()}}}}})
+	if !errors.As(err, &optionsErr) {
+		t.Errorf("Manager.Apply() error = %v, want *OptionsError", err)
+	}
+
+	if len(fake.calls) != 0 {
+		t.Errorf("invalid options ran %v", fake.calls)
+	}
+}